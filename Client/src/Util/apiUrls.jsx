@@ -11,5 +11,7 @@ export const dashboardUrlBase = 'http://localhost:3000/userAsset/';
 export const bankAccountUrlBase = 'http://localhost:3000/bankAccountAsset/';
 export const payUrl = 'http://localhost:3000/pay';
 export const removeRequestedURL = 'http://localhost:3000/removeFromRequestedOfContractor';
+export const rejectByContractorURL = 'http://localhost:3000/rejectByContractor';
+export const counterOfferURL = 'http://localhost:3000/counterOffer';
 export const removePendingURL = 'http://localhost:3000/removeFromPendingOfManager';
 export const revokeURL = 'http://localhost:3000/revoke';
\ No newline at end of file
@@ -81,6 +81,11 @@ export default function ContractCard({ contract }) {
                     <Typography variant="subtitle1" color="textPrimary" fontFamily="Arial">
                         <strong>{contract.natureOfWork}</strong>
                     </Typography>
+                    {contract.contractRef && (
+                        <Typography variant="subtitle1" color="textPrimary" fontFamily="Arial">
+                            <em>Ref:</em> {contract.contractRef}
+                        </Typography>
+                    )}
                 </Grid>
                 <Grid item md={3} lg={3} xl={3}>
                     <Typography variant="subtitle1" color="textPrimary" fontFamily="Arial">
@@ -95,7 +100,7 @@ export default function ContractCard({ contract }) {
                 </Grid>
                 <Grid item md={2} lg={2} xl={2} textAlign="end">
                     <Typography variant="http://localhost:5173/contractssubtitle1" color="textPrimary" fontFamily="Arial">
-                        <strong>{contract.ratePerInterval} {contract.rateCurrency} / {contract.interval} days</strong>
+                        <strong>{contract.ratePerInterval} {contract.rateCurrency} / {contract.interval} {contract.intervalUnit || 'days'}</strong>
                     </Typography>
                 </Grid>
                 <Grid item md={4} lg={4} xl={4} textAlign="end">
@@ -114,7 +119,7 @@ export default function ContractCard({ contract }) {
                 <form onSubmit={handleRedeem} style={{ display: 'flex', flexDirection: 'column', alignItems: 'center' }}>
                     <TextField
                         label="Current Date"
-                        placeholder="DD-MM-YYYY"
+                        placeholder="YYYY-MM-DD"
                         value={currentDate}
                         onChange={(e) => setCurrentDate(e.target.value)}
                         required
@@ -130,7 +135,7 @@ export default function ContractCard({ contract }) {
                 <form onSubmit={handleReject} style={{ display: 'flex', flexDirection: 'column', alignItems: 'center' }}>
                     <TextField
                         label="Current Date"
-                        placeholder="DD-MM-YYYY"
+                        placeholder="YYYY-MM-DD"
                         value={currentDate}
                         onChange={(e) => setCurrentDate(e.target.value)}
                         required
@@ -1,7 +1,7 @@
 import React, { useState } from 'react';
 import axios from 'axios';
 import { NewContractUrl } from '../../Util/apiUrls';
-import { TextField, Button, Container, Typography } from '@mui/material';
+import { TextField, Button, Container, Typography, Checkbox, FormControlLabel } from '@mui/material';
 
 export default function NewContract() {
     const [manager, setManager] = useState('');
@@ -11,6 +11,11 @@ export default function NewContract() {
     const [ratePerInterval, setRatePerInterval] = useState('');
     const [natureOfWork, setNatureOfWork] = useState('');
     const [startDate, setStartDate] = useState('');
+    const [intervalUnit, setIntervalUnit] = useState('days');
+    const [timeZone, setTimeZone] = useState(Intl.DateTimeFormat().resolvedOptions().timeZone);
+    const [proRate, setProRate] = useState('none');
+    const [autoRenew, setAutoRenew] = useState(false);
+    const [payBasis, setPayBasis] = useState('time');
 
     const handleSubmit = async (e) => {
         e.preventDefault();
@@ -23,7 +28,12 @@ export default function NewContract() {
                 interval,
                 ratePerInterval,
                 natureOfWork,
-                startDate
+                startDate,
+                intervalUnit,
+                timeZone,
+                proRate,
+                autoRenew,
+                payBasis
             }).then(response => {
                 console.log(response.data.message);
                 alert('Contract created');
@@ -76,7 +86,7 @@ export default function NewContract() {
                     required
                 />
                 <TextField
-                    label="Interval in Days"
+                    label="Interval"
                     value={interval}
                     onChange={(e) => setInterval(e.target.value)}
                     placeholder="7"
@@ -84,15 +94,52 @@ export default function NewContract() {
                     margin="normal"
                     required
                 />
+                <TextField
+                    select
+                    label="Interval Unit"
+                    value={intervalUnit}
+                    onChange={(e) => setIntervalUnit(e.target.value)}
+                    SelectProps={{ native: true }}
+                    fullWidth
+                    margin="normal"
+                    required
+                >
+                    <option value="days">Days</option>
+                    <option value="weeks">Weeks</option>
+                    <option value="months">Months</option>
+                </TextField>
                 <TextField
                     label="Start Date"
                     value={startDate}
                     onChange={(e) => setStartDate(e.target.value)}
-                    placeholder="DD-MM-YYYY"
+                    placeholder="YYYY-MM-DD"
+                    fullWidth
+                    margin="normal"
+                    required
+                />
+                <TextField
+                    label="Time Zone"
+                    value={timeZone}
+                    onChange={(e) => setTimeZone(e.target.value)}
+                    placeholder="Asia/Kolkata"
                     fullWidth
                     margin="normal"
                     required
                 />
+                <TextField
+                    select
+                    label="Pay Basis"
+                    value={payBasis}
+                    onChange={(e) => setPayBasis(e.target.value)}
+                    SelectProps={{ native: true }}
+                    fullWidth
+                    margin="normal"
+                    required
+                >
+                    <option value="time">Per interval</option>
+                    <option value="hourly">Per approved hour</option>
+                    <option value="milestone">Per approved deliverable</option>
+                </TextField>
                 <TextField
                     label="Rate per Interval"
                     value={ratePerInterval}
@@ -111,6 +158,23 @@ export default function NewContract() {
                     margin="normal"
                     required
                 />
+                <TextField
+                    select
+                    label="Final Partial Interval"
+                    value={proRate}
+                    onChange={(e) => setProRate(e.target.value)}
+                    SelectProps={{ native: true }}
+                    fullWidth
+                    margin="normal"
+                    required
+                >
+                    <option value="none">Not paid</option>
+                    <option value="daily">Paid per day worked</option>
+                </TextField>
+                <FormControlLabel
+                    control={<Checkbox checked={autoRenew} onChange={(e) => setAutoRenew(e.target.checked)} />}
+                    label="Renew automatically at the end of the term"
+                />
                 
                 <Button type="submit" variant="contained" color='success' style={{ display: 'block', margin: '0 auto' }}>Create Contract Asset</Button>
             </form>
@@ -60,7 +60,7 @@ export default function ContractCard({ contract }) {
                 </Grid>
                 <Grid item md={2} lg={2} xl={2} textAlign="end">
                     <Typography variant="subtitle1" color="textPrimary" fontFamily="Arial">
-                        <strong>{contract.ratePerInterval} {contract.rateCurrency} / {contract.interval} days</strong>
+                        <strong>{contract.ratePerInterval} {contract.rateCurrency} / {contract.interval} {contract.intervalUnit || 'days'}</strong>
                     </Typography>
                     <Typography variant="subtitle1" color="textPrimary" fontFamily="Arial">
                         {contract.natureOfWork}
@@ -1,5 +1,5 @@
 import { Typography, Button, Grid, TextField, MenuItem } from "@mui/material";
-import { acceptByContractorURL, removeRequestedURL } from "../../Util/apiUrls";
+import { acceptByContractorURL, rejectByContractorURL } from "../../Util/apiUrls";
 import axios from 'axios'
 import { useState } from "react";
 
@@ -30,13 +30,17 @@ export default function ContractCard({ contract }) {
     }
 
     function handleReject() {
-        // console.log("Rejected");
-        axios.put(removeRequestedURL,{
+        const reason = window.prompt('Reason for rejecting the contract');
+        if (reason === null) {
+            return;
+        }
+        axios.put(rejectByContractorURL,{
             contractId: contract.contractId,
-            contractor: contract.contractor
+            contractor: contract.contractor,
+            reason: reason
         })
         .then(response => {
-            alert('Contract Removed from Requested List')
+            alert('Contract Rejected')
             window.location.reload();
         })
         .catch(error => {
@@ -65,7 +69,7 @@ export default function ContractCard({ contract }) {
                 </Grid>
                 <Grid item md={2} lg={2} xl={2} textAlign="end">
                     <Typography variant="subtitle1" color="textPrimary" fontFamily="Arial">
-                        <strong>{contract.ratePerInterval} {contract.rateCurrency} / {contract.interval} days</strong>
+                        <strong>{contract.ratePerInterval} {contract.rateCurrency} / {contract.interval} {contract.intervalUnit || 'days'}</strong>
                     </Typography>
                     <Typography variant="subtitle1" color="textPrimary" fontFamily="Arial">
                         {contract.natureOfWork}
@@ -0,0 +1,149 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Credit is a payment to credit to an account, as delivered by a central bank
+type Credit struct {
+	AccountNo    string `json:"accountNo"`
+	Currency     string `json:"currency"`
+	Amount       int    `json:"amount"`
+	PaymentType  string `json:"paymentType"`
+	PayerCountry string `json:"payerCountry"`
+}
+
+// CreditBatch credits several payments in one transaction, withholding tax on each as CreditFunds does.
+// Central banks use it to deliver a settlement batch to this bank with a single call.
+func (s *SmartContract) CreditBatch(ctx contractapi.TransactionContextInterface, creditsJSON string) error {
+	if err := s.requireCentralBank(ctx); err != nil {
+		return err
+	}
+
+	var credits []Credit
+	if err := json.Unmarshal([]byte(creditsJSON), &credits); err != nil {
+		return fmt.Errorf("failed to parse credits: %v", err)
+	}
+
+	batch := newAccountCredits()
+	for _, credit := range credits {
+		if err := batch.credit(ctx, s, credit, false); err != nil {
+			return err
+		}
+	}
+
+	return batch.save(ctx)
+}
+
+// accountCredits applies the credits of one call to accounts held in memory, as reads within a
+// transaction do not see its own writes. An account credited several times is read once and
+// written once when the credits are saved.
+type accountCredits struct {
+	accounts map[string]*BankAccountAsset
+	next     int // index of the next credit
+}
+
+func newAccountCredits() *accountCredits {
+	return &accountCredits{accounts: map[string]*BankAccountAsset{}}
+}
+
+// credit applies a credit, skipping withholding entirely when exempt is set.
+// Accounts that do not exist are created with zero funds.
+func (c *accountCredits) credit(ctx contractapi.TransactionContextInterface, s *SmartContract, credit Credit, exempt bool) error {
+	index := c.next
+	c.next++
+
+	paymentType := credit.PaymentType
+	if paymentType != PaymentWages && paymentType != PaymentTransfer && paymentType != PaymentRefund {
+		return fmt.Errorf("unknown payment type %s", paymentType)
+	}
+
+	bankAccountAsset, ok := c.accounts[credit.AccountNo]
+	if !ok {
+		var err error
+		bankAccountAsset, err = s.GetBankAccountAsset(ctx, credit.AccountNo)
+		if err != nil {
+			return err
+		}
+		c.accounts[credit.AccountNo] = bankAccountAsset
+	}
+
+	if err := checkCanMoveFunds(bankAccountAsset, credit.Amount); err != nil {
+		return err
+	}
+
+	payerCountry := credit.PayerCountry
+	if payerCountry == "" {
+		payerCountry = residencyOf(bankAccountAsset)
+	}
+
+	ratePercent := 0.0
+	if !exempt {
+		var err error
+		ratePercent, err = s.withholdingRate(ctx, bankAccountAsset, paymentType, payerCountry)
+		if err != nil {
+			return err
+		}
+	}
+	withheld := int(float64(credit.Amount) * ratePercent / 100)
+
+	currency := credit.Currency
+	if currency == "" {
+		currency = homeCurrency(bankAccountAsset)
+	}
+	currency = strings.ToUpper(currency)
+
+	if bankAccountAsset.HighThroughput {
+		if err := s.putBalanceDelta(ctx, bankAccountAsset, currency, credit.Amount-withheld, index); err != nil {
+			return err
+		}
+	} else {
+		adjustBalance(bankAccountAsset, currency, credit.Amount-withheld)
+	}
+
+	if withheld != 0 {
+		if err := s.putTaxDelta(ctx, currency, withheld, index); err != nil {
+			return err
+		}
+	}
+	if withheld == 0 && paymentType != PaymentWages {
+		return nil
+	}
+
+	return s.putTaxEntry(ctx, bankAccountAsset, TaxEntry{
+		Currency:     currency,
+		PaymentType:  paymentType,
+		PayerCountry: strings.ToUpper(payerCountry),
+		Gross:        credit.Amount,
+		RatePercent:  ratePercent,
+		Withheld:     withheld,
+	}, index)
+}
+
+// save writes every account credited, in sorted order so every peer produces the same write set
+func (c *accountCredits) save(ctx contractapi.TransactionContextInterface) error {
+	accountNos := make([]string, 0, len(c.accounts))
+	for accountNo, bankAccountAsset := range c.accounts {
+		if !bankAccountAsset.HighThroughput {
+			accountNos = append(accountNos, accountNo)
+		}
+	}
+	sort.Strings(accountNos)
+
+	for _, accountNo := range accountNos {
+		bankAccountAssetJSON, err := json.Marshal(c.accounts[accountNo])
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(accountNo, bankAccountAssetJSON); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
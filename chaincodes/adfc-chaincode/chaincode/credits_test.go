@@ -0,0 +1,103 @@
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestCreditBatchKeepsEveryCreditToAnAccount(t *testing.T) {
+	s := &SmartContract{}
+	l := newLedger(t)
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.InitLedger(ctx)
+	})
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		if err := s.CreateBankAccountAsset(ctx, "ht1", "INR", 0, "alice", 10); err != nil {
+			return err
+		}
+		return s.CreateBankAccountAsset(ctx, "acct1", "INR", 0, "bob", 10)
+	})
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.SetHighThroughput(ctx, "ht1", true)
+	})
+
+	credits := `[
+		{"accountNo": "ht1", "amount": 100, "paymentType": "wages"},
+		{"accountNo": "ht1", "amount": 50, "paymentType": "wages"},
+		{"accountNo": "acct1", "amount": 100, "paymentType": "wages"},
+		{"accountNo": "acct1", "amount": 50, "paymentType": "transfer"}
+	]`
+	if err := l.submit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.CreditBatch(ctx, credits)
+	}); err == nil {
+		t.Fatalf("credit batch submitted by the bank admin's MSP was accepted")
+	}
+	centralBank := testIdentity{id: "inr-admin", mspID: "INRMSP"}
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.RegisterCentralBankMSP(ctx, "inr", centralBank.mspID)
+	})
+	l.mustSubmit(t, centralBank, func(ctx contractapi.TransactionContextInterface) error {
+		return s.CreditBatch(ctx, credits)
+	})
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		pending, err := s.GetPendingCredits(ctx, "ht1")
+		if err != nil {
+			return err
+		}
+		if pending["INR"] != 90+45 {
+			t.Errorf("pending credits %v, want INR %d", pending, 90+45)
+		}
+		account, err := s.GetBankAccountAsset(ctx, "acct1")
+		if err != nil {
+			return err
+		}
+		if balance := balanceOf(account, "INR"); balance != 90+50 {
+			t.Errorf("balance %d, want %d", balance, 90+50)
+		}
+		withholdingAccount, err := s.GetTaxAccount(ctx, "INR")
+		if err != nil {
+			return err
+		}
+		if withheld := balanceOf(withholdingAccount, "INR"); withheld != 10+5+10 {
+			t.Errorf("tax withheld %d, want %d", withheld, 10+5+10)
+		}
+		return nil
+	})
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		compacted, err := s.CompactBalance(ctx, "ht1")
+		if err != nil {
+			return err
+		}
+		if compacted != 2 {
+			t.Errorf("compacted %d credits, want 2", compacted)
+		}
+		if compacted, err = s.CompactTaxAccount(ctx, "INR"); err != nil {
+			return err
+		}
+		if compacted != 3 {
+			t.Errorf("compacted %d tax deltas, want 3", compacted)
+		}
+		return nil
+	})
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		account, err := s.GetBankAccountAsset(ctx, "ht1")
+		if err != nil {
+			return err
+		}
+		if balance := balanceOf(account, "INR"); balance != 90+45 {
+			t.Errorf("compacted balance %d, want %d", balance, 90+45)
+		}
+		total, err := s.GetTotalBalance(ctx, "INR")
+		if err != nil {
+			return err
+		}
+		if total != 300 {
+			t.Errorf("total balance %d, want every credit of 300", total)
+		}
+		return nil
+	})
+}
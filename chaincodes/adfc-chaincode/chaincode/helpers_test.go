@@ -0,0 +1,94 @@
+package chaincode
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// testIdentity is the client calling a transaction in tests. The chaincode only reads its id and MSP.
+type testIdentity struct {
+	cid.ClientIdentity
+	id    string
+	mspID string
+}
+
+func (i testIdentity) GetID() (string, error)    { return i.id, nil }
+func (i testIdentity) GetMSPID() (string, error) { return i.mspID, nil }
+
+var admin = testIdentity{id: "admin", mspID: "Org1MSP"}
+
+// txStub runs a call the way a peer simulates a transaction: reads see only the state committed
+// before the transaction and writes are buffered until it commits
+type txStub struct {
+	*shimtest.MockStub
+	keys   []string
+	writes map[string][]byte
+}
+
+func (t *txStub) PutState(key string, value []byte) error {
+	if _, ok := t.writes[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.writes[key] = value
+	return nil
+}
+
+func (t *txStub) DelState(key string) error {
+	return t.PutState(key, nil)
+}
+
+// ledger is the world state of the chaincode under test
+type ledger struct {
+	stub *shimtest.MockStub
+	txs  int
+}
+
+func newLedger(t *testing.T) *ledger {
+	chaincode, err := contractapi.NewChaincode(&SmartContract{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ledger{stub: shimtest.NewMockStub("adfc", chaincode)}
+}
+
+// submit runs fn as one transaction by a client and commits its writes if it succeeds
+func (l *ledger) submit(t *testing.T, client testIdentity, fn func(ctx contractapi.TransactionContextInterface) error) error {
+	l.txs++
+	txID := fmt.Sprintf("tx%d", l.txs)
+	l.stub.MockTransactionStart(txID)
+	defer l.stub.MockTransactionEnd(txID)
+
+	tx := &txStub{MockStub: l.stub, writes: map[string][]byte{}}
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(tx)
+	ctx.SetClientIdentity(client)
+
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	for _, key := range tx.keys {
+		var err error
+		if tx.writes[key] == nil {
+			err = l.stub.DelState(key)
+		} else {
+			err = l.stub.PutState(key, tx.writes[key])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return nil
+}
+
+// mustSubmit runs fn as one transaction and fails the test if it returns an error
+func (l *ledger) mustSubmit(t *testing.T, client testIdentity, fn func(ctx contractapi.TransactionContextInterface) error) {
+	t.Helper()
+	if err := l.submit(t, client, fn); err != nil {
+		t.Fatal(err)
+	}
+}
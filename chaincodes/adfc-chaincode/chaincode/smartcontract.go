@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -15,20 +16,123 @@ type SmartContract struct {
 
 // BankAccountAsset represents a bank account asset
 type BankAccountAsset struct {
-	AccountNo   string `json:"accountNo"` // Unique
-	CentralBank string `json:"centralBank"`
-	Funds       int    `json:"funds"`
-	Owner       string `json:"owner"`
-	Tax		 	int    `json:"tax"`
+	AccountNo        string         `json:"accountNo"` // Unique
+	CentralBank      string         `json:"centralBank"`
+	Funds            int            `json:"funds"`    // Balance in the home currency of CentralBank
+	Balances         map[string]int `json:"balances"` // Balance per currency
+	Owner            string         `json:"owner"`
+	Tax              int            `json:"tax"` // Withheld from wages when no tax rule applies
+	Residency        string         `json:"residency"`
+	Status           string         `json:"status"`
+	StatusReason     string         `json:"statusReason"`
+	StatusHistory    []StatusChange `json:"statusHistory,omitempty" metadata:",optional"`
+	KYCLevel         int            `json:"kycLevel"`
+	TransactionLimit int            `json:"transactionLimit"` // 0 means no limit
+	HighThroughput   bool           `json:"highThroughput"`   // credits are written as deltas, see CompactBalance
 }
 
 // InitLedger initializes the ledger with sample assets
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	// Initialize any necessary data here
+	// The first identity to initialize the ledger becomes the bank admin
+	adminKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"admin"})
+	if err != nil {
+		return err
+	}
+	adminBytes, err := ctx.GetStub().GetState(adminKey)
+	if err != nil {
+		return fmt.Errorf("failed to read admin from world state: %v", err)
+	}
+	if adminBytes != nil {
+		return nil
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	return ctx.GetStub().PutState(adminKey, []byte(clientID))
+}
+
+// requireAdmin returns an error unless the caller is the bank admin
+func (s *SmartContract) requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	adminKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"admin"})
+	if err != nil {
+		return err
+	}
+	adminBytes, err := ctx.GetStub().GetState(adminKey)
+	if err != nil {
+		return fmt.Errorf("failed to read admin from world state: %v", err)
+	}
+	if adminBytes == nil {
+		return fmt.Errorf("bank admin is not set, run InitLedger first")
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if clientID != string(adminBytes) {
+		return fmt.Errorf("caller is not the bank admin")
+	}
+
+	return nil
+}
+
+// RegisterCentralBankMSP records the MSP of a central bank's organization.
+// Members of a registered MSP may call the transactions reserved for central banks.
+// Payments forwarded to this bank are submitted by clients of the paying member banks,
+// so their MSPs must be registered as well.
+func (s *SmartContract) RegisterCentralBankMSP(ctx contractapi.TransactionContextInterface, centralBank string, mspID string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	mspKey, err := ctx.GetStub().CreateCompositeKey("centralbankmsp", []string{mspID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(mspKey, []byte(strings.ToLower(centralBank)))
+}
+
+// requireCentralBank returns an error unless the caller belongs to a registered central bank MSP.
+// Central banks invoke this chaincode on behalf of their own clients, whose identity the call keeps.
+func (s *SmartContract) requireCentralBank(ctx contractapi.TransactionContextInterface) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP: %v", err)
+	}
+	mspKey, err := ctx.GetStub().CreateCompositeKey("centralbankmsp", []string{mspID})
+	if err != nil {
+		return err
+	}
+	centralBank, err := ctx.GetStub().GetState(mspKey)
+	if err != nil {
+		return fmt.Errorf("failed to read central bank MSP from world state: %v", err)
+	}
+	if centralBank == nil {
+		return fmt.Errorf("caller's MSP %s is not a registered central bank", mspID)
+	}
 
 	return nil
 }
 
+// newPaymentId returns the id of the index-th payment made by the transaction.
+// Suffixing the transaction id keeps the records of several payments in one transaction apart.
+func newPaymentId(ctx contractapi.TransactionContextInterface, index int) string {
+	return fmt.Sprintf("%s-%d", ctx.GetStub().GetTxID(), index)
+}
+
+// txTime returns the transaction timestamp, which is identical on every endorsing peer
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	return timestamp.AsTime().UTC(), nil
+}
+
 // CreateBankAccountAsset creates a new bank account asset
 func (s *SmartContract) CreateBankAccountAsset(ctx contractapi.TransactionContextInterface, accountNo string, centralBank string, funds int, owner string, tax int) error {
 	exists, err := s.BankAccountAssetExists(ctx, accountNo)
@@ -43,8 +147,10 @@ func (s *SmartContract) CreateBankAccountAsset(ctx contractapi.TransactionContex
 		AccountNo:   accountNo,
 		CentralBank: centralBank,
 		Funds:       funds,
+		Balances:    map[string]int{strings.ToUpper(centralBank): funds},
 		Owner:       owner,
-		Tax:		 tax,
+		Tax:         tax,
+		Status:      StatusActive,
 	}
 
 	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
@@ -77,6 +183,8 @@ func (s *SmartContract) GetBankAccountAsset(ctx contractapi.TransactionContextIn
 		bankAccountAsset := BankAccountAsset{
 			AccountNo: accountNo,
 			Funds:     0,
+			Balances:  map[string]int{},
+			Status:    StatusActive,
 		}
 		bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
 		if err != nil {
@@ -95,44 +203,74 @@ func (s *SmartContract) GetBankAccountAsset(ctx contractapi.TransactionContextIn
 		return nil, err
 	}
 
+	normalizeAccount(&bankAccountAsset)
+
 	return &bankAccountAsset, nil
 }
 
-// AddFunds adds funds to a bank account asset.
+// AddFunds credits a domestic transfer in the home currency to a bank account asset.
 // If the asset does not exist, it creates a new one with zero funds.
 func (s *SmartContract) AddFunds(ctx contractapi.TransactionContextInterface, accountNo string, amount int) error {
-	bankAccountAsset, err := s.GetBankAccountAsset(ctx, accountNo)
-	if err != nil {
-		return err
-	}
-
-	taxPercent := float32(bankAccountAsset.Tax) / 100
-	percentToSend := 1 - taxPercent
+	return s.CreditFunds(ctx, accountNo, "", amount, PaymentTransfer, "")
+}
 
-	bankAccountAsset.Funds += int(float32(amount) * percentToSend)
+// CreditFunds credits a payment in a currency to a bank account asset, withholding tax according to
+// the payment type and the countries of the payer and the payee.
+// An empty currency means the home currency and
+// an empty payer country means the payer resides in the payee's country.
+// If the asset does not exist, it creates a new one with zero funds.
+func (s *SmartContract) CreditFunds(ctx contractapi.TransactionContextInterface, accountNo string, currency string, amount int, paymentType string, payerCountry string) error {
+	return s.creditFunds(ctx, accountNo, currency, amount, paymentType, payerCountry, false)
+}
 
-	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
-	if err != nil {
+// creditFunds credits a payment, skipping withholding entirely when exempt is set
+func (s *SmartContract) creditFunds(ctx contractapi.TransactionContextInterface, accountNo string, currency string, amount int, paymentType string, payerCountry string, exempt bool) error {
+	credits := newAccountCredits()
+	credit := Credit{
+		AccountNo:    accountNo,
+		Currency:     currency,
+		Amount:       amount,
+		PaymentType:  paymentType,
+		PayerCountry: payerCountry,
+	}
+	if err := credits.credit(ctx, s, credit, exempt); err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+	return credits.save(ctx)
 }
 
-// RemoveFunds removes funds from a bank account asset.
+// RemoveFunds removes funds in the home currency from a bank account asset.
 // If the asset does not exist, it returns an error.
 // If the funds are not sufficient, it returns an error.
 func (s *SmartContract) RemoveFunds(ctx contractapi.TransactionContextInterface, accountNo string, amount int) error {
-	bankAccountAsset, err := s.GetBankAccountAsset(ctx, accountNo)
-	if err != nil {
+	return s.debitFunds(ctx, accountNo, "", amount)
+}
+
+// RefundFunds returns a held amount to an account without withholding tax.
+// Central banks call it when a held payment is rejected, so only a registered central bank MSP may call it.
+// Refunds are accepted on frozen and dormant accounts but not on closed ones.
+func (s *SmartContract) RefundFunds(ctx contractapi.TransactionContextInterface, accountNo string, currency string, amount int) error {
+	if err := s.requireCentralBank(ctx); err != nil {
 		return err
 	}
 
-	if bankAccountAsset.Funds < amount {
-		return fmt.Errorf("insufficient funds in the account")
+	return s.refundFunds(ctx, accountNo, currency, amount)
+}
+
+func (s *SmartContract) refundFunds(ctx contractapi.TransactionContextInterface, accountNo string, currency string, amount int) error {
+	if amount <= 0 {
+		return fmt.Errorf("refund amount must be positive")
+	}
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return err
+	}
+	if bankAccountAsset.Status == StatusClosed {
+		return fmt.Errorf("bank account %s is closed, refund cannot be credited", accountNo)
 	}
 
-	bankAccountAsset.Funds -= amount
+	adjustBalance(bankAccountAsset, currency, amount)
 
 	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
 	if err != nil {
@@ -142,10 +280,40 @@ func (s *SmartContract) RemoveFunds(ctx contractapi.TransactionContextInterface,
 	return ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
 }
 
-func (s *SmartContract) ForeignTransfer(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string, bankAccount string) error {
+// GetAccountOwner returns the owner of an account, or an empty string if the account does not exist
+func (s *SmartContract) GetAccountOwner(ctx contractapi.TransactionContextInterface, accountNo string) (string, error) {
+	bankAccountAssetJSON, err := ctx.GetStub().GetState(accountNo)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bank account asset from world state: %v", err)
+	}
+	if bankAccountAssetJSON == nil {
+		return "", nil
+	}
+
+	var bankAccountAsset BankAccountAsset
+	err = json.Unmarshal(bankAccountAssetJSON, &bankAccountAsset)
+	if err != nil {
+		return "", err
+	}
+
+	return bankAccountAsset.Owner, nil
+}
+
+// ForeignTransfer sends an amount already debited from the payer to the central bank of its currency for conversion.
+// An empty paymentId assigns the payment the first id of the transaction.
+func (s *SmartContract) ForeignTransfer(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string, bankAccount string, bankAccountFrom string, paymentType string, quoteId string, paymentId string) error {
+
+	if paymentId == "" {
+		paymentId = newPaymentId(ctx, 0)
+	}
+
+	payer, err := s.readBankAccountAsset(ctx, bankAccountFrom)
+	if err != nil {
+		return err
+	}
 	
 	fcn := "PayCentralBnk"
-	args := [][]byte{[]byte(fcn), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount)), []byte(bank), []byte(bankAccount)}
+	args := [][]byte{[]byte(fcn), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount)), []byte(bank), []byte(bankAccount), []byte("adfc"), []byte(bankAccountFrom), []byte(payer.Owner), []byte(paymentType), []byte(residencyOf(payer)), []byte(quoteId), []byte(paymentId)}
 
 	centralBnk := strings.ToLower(currencyFrom)
 
@@ -158,29 +326,105 @@ func (s *SmartContract) ForeignTransfer(ctx contractapi.TransactionContextInterf
 	return nil	
 }
 
-func (s *SmartContract) Pay(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bankAccountFrom string, bankTo string, bankAccountTo string) error {
+// Pay moves funds from an account at this bank to an account at any bank.
+// The payment type, wages or transfer, selects the withholding tax applied to the payee.
+// Payments breaching a flagging transfer limit are debited and held for manual approval.
+// A quoteId from the forex chaincode locks the conversion rate of a cross-currency payment; pass "" for the current rate.
+func (s *SmartContract) Pay(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bankAccountFrom string, bankTo string, bankAccountTo string, paymentType string, quoteId string) error {
+
+	if paymentType != PaymentWages && paymentType != PaymentTransfer {
+		return fmt.Errorf("payment type must be %s or %s", PaymentWages, PaymentTransfer)
+	}
+	if quoteId != "" && currencyFrom == currencyTo {
+		return fmt.Errorf("forex quotes only apply to payments between currencies")
+	}
+
+	paymentId := newPaymentId(ctx, 0)
+
+	breaches, err := s.checkTransferLimits(ctx, bankAccountFrom, currencyFrom, currencyTo, amount)
+	if err != nil {
+		return err
+	}
 
-	err := s.RemoveFunds(ctx, bankAccountFrom, amount)
+	err = s.debitFunds(ctx, bankAccountFrom, currencyFrom, amount)
 	if err != nil {
 		return err
 	}
 
+	if len(breaches) > 0 {
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+
+		payment := FlaggedPayment{
+			PaymentId:       paymentId,
+			CurrencyFrom:    currencyFrom,
+			CurrencyTo:      currencyTo,
+			Amount:          amount,
+			BankAccountFrom: bankAccountFrom,
+			BankTo:          bankTo,
+			BankAccountTo:   bankAccountTo,
+			PaymentType:     paymentType,
+			QuoteId:         quoteId,
+			Breaches:        breaches,
+			Status:          PaymentPendingApproval,
+			FlaggedAt:       now.Format(time.RFC3339),
+		}
+		if err := s.putFlaggedPayment(ctx, &payment); err != nil {
+			return err
+		}
+
+		paymentJSON, err := json.Marshal(payment)
+		if err != nil {
+			return err
+		}
+
+		return ctx.GetStub().SetEvent("PaymentFlagged", paymentJSON)
+	}
+
+	err = s.recordTransferUsage(ctx, bankAccountFrom, currencyFrom, currencyTo, amount)
+	if err != nil {
+		return err
+	}
+
+	return s.settlePayment(ctx, paymentId, currencyFrom, currencyTo, amount, bankAccountFrom, bankTo, bankAccountTo, paymentType, quoteId)
+}
+
+// settlePayment delivers an amount already debited from the payer to the destination account
+func (s *SmartContract) settlePayment(ctx contractapi.TransactionContextInterface, paymentId string, currencyFrom string, currencyTo string, amount int, bankAccountFrom string, bankTo string, bankAccountTo string, paymentType string, quoteId string) error {
+
 	if(currencyFrom == currencyTo){
 
+		payer, err := s.readBankAccountAsset(ctx, bankAccountFrom)
+		if err != nil {
+			return err
+		}
+
 		if bankTo == "adfc" {
-			err = s.AddFunds(ctx, bankAccountTo, amount)
+			// Transfers between accounts of the same bank are exempt from withholding
+			err = s.creditFunds(ctx, bankAccountTo, currencyTo, amount, paymentType, residencyOf(payer), paymentType == PaymentTransfer)
 			if err != nil {
 				return err
 			}
 			return nil
 		}
 
-		fnc := "AddFunds"
-		args := [][]byte{[]byte(fnc), []byte(bankAccountTo), []byte(fmt.Sprintf("%d", amount))}
+		// Payments to another bank move reserves between the banks at the central bank of the currency
+		reserveArgs := [][]byte{[]byte("TransferReserves"), []byte("adfc"), []byte(bankTo), []byte(fmt.Sprintf("%d", amount))}
+
+		response := ctx.GetStub().InvokeChaincode(strings.ToLower(currencyFrom), reserveArgs, "")
+
+		if response.GetStatus() != 200 {
+			return fmt.Errorf("adfc to central bank chaincode reserve transfer returned %d. %s", response.GetStatus(), response.GetMessage())
+		}
+
+		fnc := "CreditFunds"
+		args := [][]byte{[]byte(fnc), []byte(bankAccountTo), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount)), []byte(paymentType), []byte(residencyOf(payer))}
 
 		contract := strings.ToLower(bankTo)
 
-		response := ctx.GetStub().InvokeChaincode(contract, args, "")
+		response = ctx.GetStub().InvokeChaincode(contract, args, "")
 
 		if response.GetStatus() != 200 {
 			return fmt.Errorf("adfc chaincode add funds invoke returned %d. %s", response.GetStatus(), response.GetMessage())
@@ -189,7 +433,7 @@ func (s *SmartContract) Pay(ctx contractapi.TransactionContextInterface, currenc
 		return nil
 	}
 
-	err = s.ForeignTransfer(ctx, currencyFrom, currencyTo, amount, bankTo, bankAccountTo)
+	err := s.ForeignTransfer(ctx, currencyFrom, currencyTo, amount, bankTo, bankAccountTo, bankAccountFrom, paymentType, quoteId, paymentId)
 	if err != nil {
 		return err
 	}
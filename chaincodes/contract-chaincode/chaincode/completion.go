@@ -0,0 +1,99 @@
+package chaincode
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Contract statuses
+const (
+	ContractActive    = "Active"
+	ContractCompleted = "Completed"
+)
+
+// Pro-rating rules for the final partial interval of a contract
+const (
+	ProRateNone  = "none"  // the partial interval is not paid
+	ProRateDaily = "daily" // the partial interval is paid for the days worked
+)
+
+// successorOf reserves the id and reference of the contract renewing an auto-renewing contract.
+// A contract that does not auto-renew has no successor and gets a zero id.
+func successorOf(ctx contractapi.TransactionContextInterface, contract ContractAsset) (int, string, error) {
+	if !contract.AutoRenew {
+		return 0, "", nil
+	}
+
+	successorId, err := newContractId(ctx, "renewal", strconv.Itoa(contract.ContractId))
+	if err != nil {
+		return 0, "", err
+	}
+	successorRef, err := contractRef(ctx, contract.Manager, successorId)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return successorId, successorRef, nil
+}
+
+// completeContract moves a contract from the contracts to the completed contracts of both parties.
+// A non-zero successorId renews it on the same terms from the day it ended, without the signed document.
+func (s *SmartContract) completeContract(ctx contractapi.TransactionContextInterface, contract *ContractAsset, completedAt string, successorId int, successorRef string) error {
+	predecessor := *contract
+	predecessor.Status = ContractCompleted
+	predecessor.CompletedAt = completedAt
+	predecessor.SuccessorId = successorId
+	if err := s.putContract(ctx, &predecessor); err != nil {
+		return err
+	}
+
+	for _, username := range []string{contract.Manager, contract.Contractor} {
+		if err := s.removeFromList(ctx, ListContracts, username, contract.ContractId); err != nil {
+			return err
+		}
+		if err := s.addToList(ctx, ListCompleted, username, contract.ContractId); err != nil {
+			return err
+		}
+	}
+
+	if successorId == 0 {
+		return nil
+	}
+
+	successor := *contract
+	successor.ContractId = successorId
+	successor.ContractRef = successorRef
+	successor.StartDate = contract.LastPaymentDate
+	successor.Status = ContractActive
+	successor.PredecessorId = contract.ContractId
+	successor.SuccessorId = 0
+	successor.CompletedAt = ""
+	successor.DisputeId = ""
+	// Signatures are recorded against the predecessor's id and dates, so the renewal is signed afresh
+	successor.DocumentHash = ""
+	if err := s.putContract(ctx, &successor); err != nil {
+		return err
+	}
+
+	for _, username := range []string{contract.Manager, contract.Contractor} {
+		if err := s.addToList(ctx, ListContracts, username, successorId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetCompletedContracts retrieves the Completed array of a user asset by username
+func (s *SmartContract) GetCompletedContracts(ctx contractapi.TransactionContextInterface, username string) ([]ContractAsset, error) {
+	userAsset, err := s.GetUserAsset(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if userAsset.Completed == nil {
+		return []ContractAsset{}, nil
+	}
+
+	return userAsset.Completed, nil
+}
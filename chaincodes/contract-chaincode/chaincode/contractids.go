@@ -0,0 +1,51 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxContractId keeps derived contract ids exact in JavaScript clients
+const maxContractId = 1<<53 - 1
+
+// newContractId derives a contract id from the transaction id and the parts that distinguish contracts
+// created in the same transaction. Creations touch no shared key, so concurrent proposals do not conflict.
+// The id is reserved under its own key so a collision fails the transaction instead of overwriting a contract.
+func newContractId(ctx contractapi.TransactionContextInterface, parts ...string) (int, error) {
+	digest := sha256.Sum256([]byte(ctx.GetStub().GetTxID() + "\x00" + strings.Join(parts, "\x00")))
+	contractId := int(binary.BigEndian.Uint64(digest[:8])%maxContractId) + 1
+
+	idKey, err := ctx.GetStub().CreateCompositeKey("contractid", []string{strconv.Itoa(contractId)})
+	if err != nil {
+		return 0, err
+	}
+	existing, err := ctx.GetStub().GetState(idKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read contract id from world state: %v", err)
+	}
+	if existing != nil {
+		return 0, fmt.Errorf("contract id %d is already taken, retry the transaction", contractId)
+	}
+	if err := ctx.GetStub().PutState(idKey, []byte(ctx.GetStub().GetTxID())); err != nil {
+		return 0, err
+	}
+
+	return contractId, nil
+}
+
+// contractRef derives the human-friendly reference of a new contract from its manager, the transaction date
+// and its id, as manager-YYYYMMDD-<id in base 36>. The id is reserved, so the reference is unique without a
+// counter shared by the manager's creations.
+func contractRef(ctx contractapi.TransactionContextInterface, manager string, contractId int) (string, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s-%s", manager, now.Format("20060102"), strings.ToUpper(strconv.FormatInt(int64(contractId), 36))), nil
+}
@@ -0,0 +1,232 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+	// Embedded so time zones resolve in chaincode containers without a zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Contract dates are ISO-8601 calendar dates. Records created before time zones were introduced
+// use the legacy day-month-year layout and are still accepted.
+const (
+	dateLayout       = "2006-01-02"
+	legacyDateLayout = "02-01-2006"
+)
+
+// Interval units
+const (
+	IntervalDays   = "days"
+	IntervalWeeks  = "weeks"
+	IntervalMonths = "months"
+)
+
+// defaultDateToleranceHours lets callers ahead of or behind UTC name their own calendar date
+const defaultDateToleranceHours = 14
+
+// DateCorrection records an admin-authorized redemption as of a date other than the transaction date
+type DateCorrection struct {
+	TxId        string `json:"txId"`
+	Transaction string `json:"transaction"`
+	Subject     string `json:"subject"` // the contract id, or the payroll date
+	AsOfDate    string `json:"asOfDate"`
+	TxTimestamp string `json:"txTimestamp"`
+	CorrectedBy string `json:"correctedBy"`
+}
+
+// SetDateTolerance sets how many hours a requested as-of date may be from the transaction timestamp
+// before it is treated as an admin correction
+func (s *SmartContract) SetDateTolerance(ctx contractapi.TransactionContextInterface, hours int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	if hours < 0 {
+		return fmt.Errorf("date tolerance cannot be negative")
+	}
+
+	toleranceKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"dateTolerance"})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(toleranceKey, []byte(strconv.Itoa(hours)))
+}
+
+// GetDateTolerance returns the date tolerance in hours
+func (s *SmartContract) GetDateTolerance(ctx contractapi.TransactionContextInterface) (int, error) {
+	toleranceKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"dateTolerance"})
+	if err != nil {
+		return 0, err
+	}
+	toleranceBytes, err := ctx.GetStub().GetState(toleranceKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read date tolerance from world state: %v", err)
+	}
+	if toleranceBytes == nil {
+		return defaultDateToleranceHours, nil
+	}
+
+	return strconv.Atoi(string(toleranceBytes))
+}
+
+// asOf is the point in time a date-dependent transaction runs at. An explicit date is a calendar date
+// shared by every contract; otherwise each contract's current date is taken in its own time zone.
+type asOf struct {
+	at   time.Time
+	date time.Time
+}
+
+// localDate returns the calendar date of the as-of point in a time zone
+func (a asOf) localDate(loc *time.Location) time.Time {
+	if !a.date.IsZero() {
+		return a.date
+	}
+	y, m, d := a.at.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// String returns the as-of calendar date, taken in UTC when no explicit date was given
+func (a asOf) String() string {
+	return formatDate(a.localDate(time.UTC))
+}
+
+// effectiveDate returns the point a date-dependent transaction runs as of. An empty asOfDate means the
+// transaction timestamp. A date whose day lies within the tolerance of the transaction timestamp is accepted
+// as the caller's local date; any other date requires the admin and is logged as a correction.
+func (s *SmartContract) effectiveDate(ctx contractapi.TransactionContextInterface, transaction string, subject string, asOfDate string) (asOf, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return asOf{}, err
+	}
+	if asOfDate == "" {
+		return asOf{at: now}, nil
+	}
+
+	day, err := parseDate(asOfDate)
+	if err != nil {
+		return asOf{}, fmt.Errorf("failed to parse as of date: %v", err)
+	}
+
+	toleranceHours, err := s.GetDateTolerance(ctx)
+	if err != nil {
+		return asOf{}, err
+	}
+	tolerance := time.Duration(toleranceHours) * time.Hour
+	if !day.After(now.Add(tolerance)) && day.Add(24*time.Hour).After(now.Add(-tolerance)) {
+		return asOf{at: now, date: day}, nil
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return asOf{}, fmt.Errorf("as of date %s is outside the date tolerance: %v", asOfDate, err)
+	}
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return asOf{}, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	correction := DateCorrection{
+		TxId:        ctx.GetStub().GetTxID(),
+		Transaction: transaction,
+		Subject:     subject,
+		AsOfDate:    formatDate(day),
+		TxTimestamp: now.Format(time.RFC3339),
+		CorrectedBy: clientID,
+	}
+	correctionKey, err := ctx.GetStub().CreateCompositeKey("correction", []string{correction.TxId, subject})
+	if err != nil {
+		return asOf{}, err
+	}
+	correctionJSON, err := json.Marshal(correction)
+	if err != nil {
+		return asOf{}, err
+	}
+	if err := ctx.GetStub().PutState(correctionKey, correctionJSON); err != nil {
+		return asOf{}, err
+	}
+	if err := ctx.GetStub().SetEvent("DateCorrection", correctionJSON); err != nil {
+		return asOf{}, err
+	}
+
+	return asOf{at: now, date: day}, nil
+}
+
+// GetDateCorrections lists every logged admin date correction
+func (s *SmartContract) GetDateCorrections(ctx contractapi.TransactionContextInterface) ([]DateCorrection, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("correction", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	corrections := []DateCorrection{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var correction DateCorrection
+		if err := json.Unmarshal(queryResponse.Value, &correction); err != nil {
+			return nil, err
+		}
+		corrections = append(corrections, correction)
+	}
+
+	return corrections, nil
+}
+
+// parseDate parses an ISO-8601 or legacy contract date as a calendar date
+func parseDate(date string) (time.Time, error) {
+	if parsed, err := time.Parse(dateLayout, date); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, date); err == nil {
+		y, m, d := parsed.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	return time.Parse(legacyDateLayout, date)
+}
+
+func formatDate(date time.Time) string {
+	return date.Format(dateLayout)
+}
+
+// contractLocation returns the time zone that governs when a contract's days end. Legacy contracts use UTC.
+func contractLocation(contract ContractAsset) (*time.Location, error) {
+	if contract.TimeZone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(contract.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load time zone: %v", err)
+	}
+
+	return loc, nil
+}
+
+func validIntervalUnit(unit string) bool {
+	return unit == IntervalDays || unit == IntervalWeeks || unit == IntervalMonths
+}
+
+// intervalBoundary returns the date n intervals after the start date. Monthly intervals fall on the
+// start day of each month, or the month's last day when it is shorter.
+func intervalBoundary(start time.Time, unit string, interval int, n int) time.Time {
+	switch unit {
+	case IntervalMonths:
+		y, m, d := start.Date()
+		first := time.Date(y, m+time.Month(n*interval), 1, 0, 0, 0, 0, time.UTC)
+		if last := first.AddDate(0, 1, -1).Day(); d > last {
+			d = last
+		}
+		return first.AddDate(0, 0, d-1)
+	case IntervalWeeks:
+		return start.AddDate(0, 0, 7*n*interval)
+	default:
+		return start.AddDate(0, 0, n*interval)
+	}
+}
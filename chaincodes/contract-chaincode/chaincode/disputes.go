@@ -0,0 +1,392 @@
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Dispute statuses
+const (
+	DisputeOpen     = "Open"
+	DisputeResolved = "Resolved"
+)
+
+// Payment split roles used while a dispute holds payments in escrow
+const (
+	RoleEscrow = "escrow"
+	RoleRefund = "refund"
+)
+
+// EscrowAccount is the bank account that holds payments on disputed contracts in one currency
+type EscrowAccount struct {
+	Currency      string `json:"currency"`
+	Bank          string `json:"bank"`
+	BankAccountNo string `json:"bankAccountNo"`
+}
+
+// Evidence is a document submitted to a dispute, recorded by its SHA-256 hash
+type Evidence struct {
+	SubmittedBy  string `json:"submittedBy"`
+	DocumentHash string `json:"documentHash"`
+	Description  string `json:"description"`
+	SubmittedAt  string `json:"submittedAt"`
+}
+
+// Dispute is a manager or contractor contesting a contract. While it is open every redemption
+// on the contract is paid into escrow instead of to the payees.
+type Dispute struct {
+	DisputeId  string     `json:"disputeId"`
+	ContractId int        `json:"contractId"`
+	Manager    string     `json:"manager"`
+	Contractor string     `json:"contractor"`
+	RaisedBy   string     `json:"raisedBy"`
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	Escrowed   int        `json:"escrowed"` // in the contract's rate currency
+	Currency   string     `json:"currency"`
+	Evidence   []Evidence `json:"evidence"`
+	RaisedAt   string     `json:"raisedAt"`
+	Resolution string     `json:"resolution"`
+	Released   int        `json:"released"` // paid from escrow to the payees, the rest is refunded to the manager
+	Additional int        `json:"additional"`
+	ResolvedBy string     `json:"resolvedBy"`
+	ResolvedAt string     `json:"resolvedAt"`
+}
+
+// DisputeResolution carries the payments that settle a resolved dispute. Escrow payments are made from
+// the escrow account; additional payments awarded to the payees are made from the manager's account.
+type DisputeResolution struct {
+	Dispute              *Dispute       `json:"dispute"`
+	Escrow               *EscrowAccount `json:"escrow"`
+	ManagerBank          string         `json:"managerBank"`
+	ManagerBankAccountNo string         `json:"managerBankAccountNo"`
+	EscrowPayments       []PaymentSplit `json:"escrowPayments"`
+	AdditionalPayments   []PaymentSplit `json:"additionalPayments"`
+}
+
+// SetArbitrator designates the client identity that resolves disputes
+func (s *SmartContract) SetArbitrator(ctx contractapi.TransactionContextInterface, arbitratorId string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	if arbitratorId == "" {
+		return fmt.Errorf("arbitrator identity cannot be empty")
+	}
+
+	arbitratorKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"arbitrator"})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(arbitratorKey, []byte(arbitratorId))
+}
+
+// SetEscrowAccount sets the bank account that holds disputed payments in a currency
+func (s *SmartContract) SetEscrowAccount(ctx contractapi.TransactionContextInterface, currency string, bank string, bankAccountNo string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	escrowKey, err := ctx.GetStub().CreateCompositeKey("escrow", []string{currency})
+	if err != nil {
+		return err
+	}
+	escrowJSON, err := json.Marshal(EscrowAccount{Currency: currency, Bank: bank, BankAccountNo: bankAccountNo})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(escrowKey, escrowJSON)
+}
+
+// GetEscrowAccount returns the escrow account for a currency
+func (s *SmartContract) GetEscrowAccount(ctx contractapi.TransactionContextInterface, currency string) (*EscrowAccount, error) {
+	escrowKey, err := ctx.GetStub().CreateCompositeKey("escrow", []string{currency})
+	if err != nil {
+		return nil, err
+	}
+	escrowJSON, err := ctx.GetStub().GetState(escrowKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read escrow account from world state: %v", err)
+	}
+	if escrowJSON == nil {
+		return nil, fmt.Errorf("no escrow account is set for %s", currency)
+	}
+
+	var escrow EscrowAccount
+	if err := json.Unmarshal(escrowJSON, &escrow); err != nil {
+		return nil, err
+	}
+
+	return &escrow, nil
+}
+
+// RaiseDispute opens a dispute on an active contract by its manager or contractor.
+// Redemptions are paid into escrow until the arbitrator resolves it.
+func (s *SmartContract) RaiseDispute(ctx contractapi.TransactionContextInterface, contractId int, username string, reason string) (*Dispute, error) {
+	contract, err := s.listed(ctx, ListContracts, username, contractId)
+	if err != nil {
+		return nil, err
+	}
+	if contract.DisputeId != "" {
+		return nil, fmt.Errorf("contract %d already has an open dispute %s", contractId, contract.DisputeId)
+	}
+	// Escrow has to be possible before payments can be withheld
+	if _, err := s.GetEscrowAccount(ctx, contract.RateCurrency); err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dispute := Dispute{
+		DisputeId:  ctx.GetStub().GetTxID(),
+		ContractId: contractId,
+		Manager:    contract.Manager,
+		Contractor: contract.Contractor,
+		RaisedBy:   username,
+		Reason:     reason,
+		Status:     DisputeOpen,
+		Currency:   contract.RateCurrency,
+		Evidence:   []Evidence{},
+		RaisedAt:   now.Format(time.RFC3339),
+	}
+
+	contract.DisputeId = dispute.DisputeId
+	if err := s.putContract(ctx, contract); err != nil {
+		return nil, err
+	}
+
+	return &dispute, s.putDispute(ctx, &dispute, "DisputeRaised")
+}
+
+// SubmitEvidence adds the hex SHA-256 hash of a document to an open dispute.
+// Only the manager and the contractor of the disputed contract can submit evidence.
+func (s *SmartContract) SubmitEvidence(ctx contractapi.TransactionContextInterface, disputeId string, username string, documentHash string, description string) error {
+	dispute, err := s.GetDispute(ctx, disputeId)
+	if err != nil {
+		return err
+	}
+	if dispute.Status != DisputeOpen {
+		return fmt.Errorf("dispute %s is %s", disputeId, dispute.Status)
+	}
+	if username != dispute.Manager && username != dispute.Contractor {
+		return fmt.Errorf("%s is not a party to dispute %s", username, disputeId)
+	}
+	if hash, err := hex.DecodeString(documentHash); err != nil || len(hash) != 32 {
+		return fmt.Errorf("document hash must be a hex encoded SHA-256 hash")
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	dispute.Evidence = append(dispute.Evidence, Evidence{
+		SubmittedBy:  username,
+		DocumentHash: documentHash,
+		Description:  description,
+		SubmittedAt:  now.Format(time.RFC3339),
+	})
+
+	return s.putDispute(ctx, dispute, "EvidenceSubmitted")
+}
+
+// ResolveDispute closes a dispute. Only the designated arbitrator can resolve disputes.
+// releasePercent of the escrowed amount is released to the contract's payees and the rest is refunded to
+// the manager; additionalAmount is a further sum the manager is ordered to pay the payees.
+func (s *SmartContract) ResolveDispute(ctx contractapi.TransactionContextInterface, disputeId string, releasePercent int, additionalAmount int, resolution string) (*DisputeResolution, error) {
+	arbitratorKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"arbitrator"})
+	if err != nil {
+		return nil, err
+	}
+	arbitrator, err := ctx.GetStub().GetState(arbitratorKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read arbitrator from world state: %v", err)
+	}
+	if arbitrator == nil {
+		return nil, fmt.Errorf("no arbitrator has been designated")
+	}
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if clientID != string(arbitrator) {
+		return nil, fmt.Errorf("caller is not the arbitrator")
+	}
+
+	if releasePercent < 0 || releasePercent > 100 {
+		return nil, fmt.Errorf("release percentage must be between 0 and 100")
+	}
+	if additionalAmount < 0 {
+		return nil, fmt.Errorf("additional amount cannot be negative")
+	}
+
+	dispute, err := s.GetDispute(ctx, disputeId)
+	if err != nil {
+		return nil, err
+	}
+	if dispute.Status != DisputeOpen {
+		return nil, fmt.Errorf("dispute %s is %s", disputeId, dispute.Status)
+	}
+
+	contract, err := s.getContract(ctx, dispute.ContractId)
+	if err != nil {
+		return nil, err
+	}
+	escrow, err := s.GetEscrowAccount(ctx, dispute.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dispute.Released = dispute.Escrowed * releasePercent / 100
+	dispute.Additional = additionalAmount
+	dispute.Resolution = resolution
+	dispute.Status = DisputeResolved
+	dispute.ResolvedBy = clientID
+	dispute.ResolvedAt = now.Format(time.RFC3339)
+
+	result := DisputeResolution{
+		Dispute:              dispute,
+		Escrow:               escrow,
+		ManagerBank:          contract.ManagerBank,
+		ManagerBankAccountNo: contract.ManagerBankAccountNo,
+		EscrowPayments:       paymentSplits(*contract, dispute.Released),
+		AdditionalPayments:   paymentSplits(*contract, additionalAmount),
+	}
+	if refund := dispute.Escrowed - dispute.Released; refund > 0 {
+		result.EscrowPayments = append(result.EscrowPayments, PaymentSplit{
+			Username:      contract.Manager,
+			Role:          RoleRefund,
+			Amount:        refund,
+			Currency:      contract.RateCurrency,
+			Bank:          contract.ManagerBank,
+			BankAccountNo: contract.ManagerBankAccountNo,
+			PaymentType:   PaymentRefund,
+		})
+	}
+
+	contract.DisputeId = ""
+	if err := s.putContract(ctx, contract); err != nil {
+		return nil, err
+	}
+
+	return &result, s.putDispute(ctx, dispute, "DisputeResolved")
+}
+
+// GetDispute retrieves a dispute by its id
+func (s *SmartContract) GetDispute(ctx contractapi.TransactionContextInterface, disputeId string) (*Dispute, error) {
+	disputeKey, err := ctx.GetStub().CreateCompositeKey("dispute", []string{disputeId})
+	if err != nil {
+		return nil, err
+	}
+	disputeJSON, err := ctx.GetStub().GetState(disputeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dispute from world state: %v", err)
+	}
+	if disputeJSON == nil {
+		return nil, fmt.Errorf("dispute %s does not exist", disputeId)
+	}
+
+	var dispute Dispute
+	if err := json.Unmarshal(disputeJSON, &dispute); err != nil {
+		return nil, err
+	}
+
+	return &dispute, nil
+}
+
+// GetContractDisputes lists every dispute raised on a contract
+func (s *SmartContract) GetContractDisputes(ctx contractapi.TransactionContextInterface, contractId int) ([]Dispute, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("dispute", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	disputes := []Dispute{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var dispute Dispute
+		if err := json.Unmarshal(queryResponse.Value, &dispute); err != nil {
+			return nil, err
+		}
+		if dispute.ContractId == contractId {
+			disputes = append(disputes, dispute)
+		}
+	}
+
+	return disputes, nil
+}
+
+func (s *SmartContract) putDispute(ctx contractapi.TransactionContextInterface, dispute *Dispute, event string) error {
+	disputeKey, err := ctx.GetStub().CreateCompositeKey("dispute", []string{dispute.DisputeId})
+	if err != nil {
+		return err
+	}
+	disputeJSON, err := json.Marshal(dispute)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(disputeKey, disputeJSON); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(event, disputeJSON)
+}
+
+// splitsFor returns the payments for a redeemed amount on a contract. While the contract is
+// disputed the whole amount is paid into the escrow account instead of to its parties.
+func (s *SmartContract) splitsFor(ctx contractapi.TransactionContextInterface, contract ContractAsset, amount int) ([]PaymentSplit, error) {
+	if contract.DisputeId == "" {
+		return paymentSplits(contract, amount), nil
+	}
+	if amount <= 0 {
+		return []PaymentSplit{}, nil
+	}
+
+	escrow, err := s.GetEscrowAccount(ctx, contract.RateCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	return []PaymentSplit{{
+		Username:      contract.Manager,
+		Role:          RoleEscrow,
+		Amount:        amount,
+		Currency:      escrow.Currency,
+		Bank:          escrow.Bank,
+		BankAccountNo: escrow.BankAccountNo,
+		PaymentType:   PaymentTransfer,
+	}}, nil
+}
+
+// holdInEscrow adds a redemption on a disputed contract to the amount its dispute holds in escrow
+func (s *SmartContract) holdInEscrow(ctx contractapi.TransactionContextInterface, contract ContractAsset, amount int) error {
+	if contract.DisputeId == "" || amount <= 0 {
+		return nil
+	}
+
+	dispute, err := s.GetDispute(ctx, contract.DisputeId)
+	if err != nil {
+		return err
+	}
+	dispute.Escrowed += amount
+
+	return s.putDispute(ctx, dispute, "PaymentEscrowed")
+}
@@ -0,0 +1,300 @@
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ContractTerms are the terms both parties sign together with the contract document. Their JSON encoding,
+// in this field order, is the canonical form whose SHA-256 digest is signed.
+type ContractTerms struct {
+	ContractId      int    `json:"contractId"`
+	Manager         string `json:"manager"`
+	Contractor      string `json:"contractor"`
+	Duration        int    `json:"duration"`
+	Interval        int    `json:"interval"`
+	IntervalUnit    string `json:"intervalUnit"`
+	RatePerInterval int    `json:"ratePerInterval"`
+	RateCurrency    string `json:"rateCurrency"`
+	NatureOfWork    string `json:"natureOfWork"`
+	StartDate       string `json:"startDate"`
+	TimeZone        string `json:"timeZone"`
+	ProRate         string `json:"proRate"`
+	AutoRenew       bool   `json:"autoRenew"`
+	PayBasis        string `json:"payBasis"`
+	DocumentHash    string `json:"documentHash"`
+}
+
+// ContractSignature is a party's ECDSA signature over the canonical terms, with the public key it was verified against
+type ContractSignature struct {
+	Username  string `json:"username"`
+	Signature string `json:"signature"` // base64 ASN.1 DER
+	PublicKey string `json:"publicKey"` // PEM, as registered when signing
+	SignedAt  string `json:"signedAt"`
+}
+
+// ContractDocument anchors the off-chain agreement of a contract by its hash and the parties' signatures
+type ContractDocument struct {
+	ContractId   int                 `json:"contractId"`
+	Manager      string              `json:"manager"`
+	Contractor   string              `json:"contractor"`
+	DocumentHash string              `json:"documentHash"`
+	Signatures   []ContractSignature `json:"signatures"`
+}
+
+// DocumentVerification is the result of checking a document against a contract
+type DocumentVerification struct {
+	ContractId       int    `json:"contractId"`
+	DocumentHash     string `json:"documentHash"`
+	HashMatches      bool   `json:"hashMatches"`
+	ManagerSigned    bool   `json:"managerSigned"`    // a valid manager signature over the current terms
+	ContractorSigned bool   `json:"contractorSigned"` // a valid contractor signature over the current terms
+	Verified         bool   `json:"verified"`
+}
+
+// RegisterPublicKey sets the PEM encoded ECDSA public key a user signs contract documents with
+func (s *SmartContract) RegisterPublicKey(ctx contractapi.TransactionContextInterface, username string, password string, publicKey string) error {
+	userAsset, err := s.userProfile(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(userAsset.Password), []byte(password)); err != nil {
+		return fmt.Errorf("incorrect password for %s", username)
+	}
+	if _, err := parsePublicKey(publicKey); err != nil {
+		return err
+	}
+
+	userAsset.PublicKey = publicKey
+
+	return s.putUserProfile(ctx, userAsset)
+}
+
+// AttachContractDocument sets the hex SHA-256 hash of the agreement behind a requested contract.
+// Attaching a new document discards the signatures over the previous one.
+func (s *SmartContract) AttachContractDocument(ctx contractapi.TransactionContextInterface, contractId int, manager string, contractor string, documentHash string) error {
+	if hash, err := hex.DecodeString(documentHash); err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("document hash must be a hex encoded SHA-256 hash")
+	}
+
+	contract, err := s.listed(ctx, ListRequests, contractor, contractId)
+	if err != nil {
+		return err
+	}
+	if contract.Manager != manager {
+		return fmt.Errorf("only the manager can attach the contract document")
+	}
+	contract.DocumentHash = documentHash
+	if err := s.putContract(ctx, contract); err != nil {
+		return err
+	}
+
+	return s.putContractDocument(ctx, &ContractDocument{
+		ContractId:   contractId,
+		Manager:      manager,
+		Contractor:   contractor,
+		DocumentHash: documentHash,
+		Signatures:   []ContractSignature{},
+	})
+}
+
+// GetContractTerms returns the canonical terms of a contract that the parties sign.
+// A signature is the SHA-256 digest of this string signed with the party's registered key.
+func (s *SmartContract) GetContractTerms(ctx contractapi.TransactionContextInterface, contractId int) (string, error) {
+	document, err := s.GetContractDocument(ctx, contractId)
+	if err != nil {
+		return "", err
+	}
+	terms, err := s.canonicalTerms(ctx, document)
+	if err != nil {
+		return "", err
+	}
+
+	return string(terms), nil
+}
+
+// SignContract records a party's base64 ASN.1 ECDSA signature over the canonical terms of a contract.
+// The signature is checked against the party's registered public key before it is stored.
+func (s *SmartContract) SignContract(ctx contractapi.TransactionContextInterface, contractId int, username string, signature string) error {
+	document, err := s.GetContractDocument(ctx, contractId)
+	if err != nil {
+		return err
+	}
+	if username != document.Manager && username != document.Contractor {
+		return fmt.Errorf("%s is not a party to contract %d", username, contractId)
+	}
+
+	userAsset, err := s.userProfile(ctx, username)
+	if err != nil {
+		return err
+	}
+	if userAsset.PublicKey == "" {
+		return fmt.Errorf("%s has not registered a public key", username)
+	}
+
+	terms, err := s.canonicalTerms(ctx, document)
+	if err != nil {
+		return err
+	}
+	if !verifySignature(userAsset.PublicKey, terms, signature) {
+		return fmt.Errorf("signature does not match the contract terms and the public key of %s", username)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	signed := ContractSignature{
+		Username:  username,
+		Signature: signature,
+		PublicKey: userAsset.PublicKey,
+		SignedAt:  now.Format(time.RFC3339),
+	}
+	// A party signing again replaces their earlier signature
+	signatures := []ContractSignature{}
+	for _, existing := range document.Signatures {
+		if existing.Username != username {
+			signatures = append(signatures, existing)
+		}
+	}
+	document.Signatures = append(signatures, signed)
+
+	return s.putContractDocument(ctx, document)
+}
+
+// VerifyContractDocument checks that a document hash is the one anchored to a contract and that both
+// parties have signed the contract's current terms with it
+func (s *SmartContract) VerifyContractDocument(ctx contractapi.TransactionContextInterface, contractId int, hash string) (*DocumentVerification, error) {
+	document, err := s.GetContractDocument(ctx, contractId)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := s.canonicalTerms(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+
+	verification := DocumentVerification{
+		ContractId:   contractId,
+		DocumentHash: document.DocumentHash,
+		HashMatches:  document.DocumentHash == hash,
+	}
+	for _, signed := range document.Signatures {
+		valid := verifySignature(signed.PublicKey, terms, signed.Signature)
+		switch signed.Username {
+		case document.Manager:
+			verification.ManagerSigned = valid
+		case document.Contractor:
+			verification.ContractorSigned = valid
+		}
+	}
+	verification.Verified = verification.HashMatches && verification.ManagerSigned && verification.ContractorSigned
+
+	return &verification, nil
+}
+
+// GetContractDocument retrieves the document anchored to a contract
+func (s *SmartContract) GetContractDocument(ctx contractapi.TransactionContextInterface, contractId int) (*ContractDocument, error) {
+	documentKey, err := ctx.GetStub().CreateCompositeKey("document", []string{strconv.Itoa(contractId)})
+	if err != nil {
+		return nil, err
+	}
+	documentJSON, err := ctx.GetStub().GetState(documentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contract document from world state: %v", err)
+	}
+	if documentJSON == nil {
+		return nil, fmt.Errorf("no document is attached to contract %d", contractId)
+	}
+
+	var document ContractDocument
+	if err := json.Unmarshal(documentJSON, &document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+func (s *SmartContract) putContractDocument(ctx contractapi.TransactionContextInterface, document *ContractDocument) error {
+	documentKey, err := ctx.GetStub().CreateCompositeKey("document", []string{strconv.Itoa(document.ContractId)})
+	if err != nil {
+		return err
+	}
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(documentKey, documentJSON)
+}
+
+// canonicalTerms encodes the terms of a contract the parties sign.
+// Terms changed since signing, such as by a counter offer, no longer match earlier signatures.
+func (s *SmartContract) canonicalTerms(ctx contractapi.TransactionContextInterface, document *ContractDocument) ([]byte, error) {
+	contract, err := s.getContract(ctx, document.ContractId)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ContractTerms{
+		ContractId:      contract.ContractId,
+		Manager:         contract.Manager,
+		Contractor:      contract.Contractor,
+		Duration:        contract.Duration,
+		Interval:        contract.Interval,
+		IntervalUnit:    contract.IntervalUnit,
+		RatePerInterval: contract.RatePerInterval,
+		RateCurrency:    contract.RateCurrency,
+		NatureOfWork:    contract.NatureOfWork,
+		StartDate:       contract.StartDate,
+		TimeZone:        contract.TimeZone,
+		ProRate:         contract.ProRate,
+		AutoRenew:       contract.AutoRenew,
+		PayBasis:        contract.PayBasis,
+		DocumentHash:    document.DocumentHash,
+	})
+}
+
+func parsePublicKey(publicKey string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, fmt.Errorf("public key must be PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key must be an ECDSA key")
+	}
+
+	return ecdsaKey, nil
+}
+
+// verifySignature checks a base64 ASN.1 ECDSA signature over the SHA-256 digest of the terms
+func verifySignature(publicKey string, terms []byte, signature string) bool {
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(terms)
+
+	return ecdsa.VerifyASN1(key, digest[:], sig)
+}
@@ -0,0 +1,392 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The benchmarks compare the read and write sets of create, accept and redeem in the keyed layout with
+// the layout from before contracts had their own keys, where each operation read and rewrote the whole
+// user assets of both parties. The manager holds existingContracts contracts, as a busy manager would.
+//
+//	go test -bench . -run '^$' ./chaincode
+const existingContracts = 200
+
+// countingStub counts the keys and bytes a transaction reads and writes
+type countingStub struct {
+	*shimtest.MockStub
+	counting    bool
+	reads       int
+	writes      int
+	readBytes   int
+	writeBytes  int
+	transaction int
+}
+
+func (c *countingStub) GetState(key string) ([]byte, error) {
+	value, err := c.MockStub.GetState(key)
+	if c.counting {
+		c.reads++
+		c.readBytes += len(value)
+	}
+	return value, err
+}
+
+func (c *countingStub) PutState(key string, value []byte) error {
+	if c.counting {
+		c.writes++
+		c.writeBytes += len(value)
+	}
+	return c.MockStub.PutState(key, value)
+}
+
+func (c *countingStub) DelState(key string) error {
+	if c.counting {
+		c.writes++
+	}
+	return c.MockStub.DelState(key)
+}
+
+func newCountingStub(b *testing.B) *countingStub {
+	chaincode, err := contractapi.NewChaincode(&SmartContract{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &countingStub{MockStub: shimtest.NewMockStub("contract", chaincode)}
+}
+
+// run runs fn as one transaction, counting its reads and writes if counted is set
+func (c *countingStub) run(b *testing.B, counted bool, fn func(ctx contractapi.TransactionContextInterface) error) {
+	c.transaction++
+	txID := fmt.Sprintf("tx%d", c.transaction)
+	c.MockTransactionStart(txID)
+	defer c.MockTransactionEnd(txID)
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(c)
+
+	c.counting = counted
+	err := fn(ctx)
+	c.counting = false
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func (c *countingStub) report(b *testing.B) {
+	b.ReportMetric(float64(c.reads)/float64(b.N), "reads/op")
+	b.ReportMetric(float64(c.writes)/float64(b.N), "writes/op")
+	b.ReportMetric(float64(c.readBytes)/float64(b.N), "readB/op")
+	b.ReportMetric(float64(c.writeBytes)/float64(b.N), "writeB/op")
+}
+
+// A workflow step benchmarked in both layouts. prepare runs uncounted and returns what the step needs.
+type layoutStep struct {
+	prepare func(b *testing.B, c *countingStub, contractor string) int
+	run     func(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error
+}
+
+func benchmarkLayouts(b *testing.B, legacy layoutStep, keyed layoutStep) {
+	for _, layout := range []struct {
+		name  string
+		setup func(b *testing.B, c *countingStub)
+		step  layoutStep
+	}{
+		{"legacy", setupLegacy, legacy},
+		{"keyed", setupKeyed, keyed},
+	} {
+		b.Run(layout.name, func(b *testing.B) {
+			c := newCountingStub(b)
+			layout.setup(b, c)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				// Each contractor is new, so only the manager's contracts grow the read sets
+				contractor := "contractor" + strconv.Itoa(i)
+				c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+					return newUser(ctx, contractor)
+				})
+				contractId := 0
+				if layout.step.prepare != nil {
+					contractId = layout.step.prepare(b, c, contractor)
+				}
+				b.StartTimer()
+
+				c.run(b, true, func(ctx contractapi.TransactionContextInterface) error {
+					return layout.step.run(ctx, contractor, contractId)
+				})
+			}
+
+			c.report(b)
+		})
+	}
+}
+
+func BenchmarkCreateContract(b *testing.B) {
+	benchmarkLayouts(b,
+		layoutStep{run: legacyCreate},
+		layoutStep{run: keyedCreate},
+	)
+}
+
+func BenchmarkAcceptContract(b *testing.B) {
+	benchmarkLayouts(b,
+		layoutStep{
+			prepare: legacyPrepare(),
+			run:     legacyAccept,
+		},
+		layoutStep{
+			prepare: keyedPrepare(),
+			run:     keyedAccept,
+		},
+	)
+}
+
+func BenchmarkRedeemContract(b *testing.B) {
+	benchmarkLayouts(b,
+		layoutStep{
+			prepare: legacyPrepare(legacyAccept, legacyActivate),
+			run:     legacyRedeem,
+		},
+		layoutStep{
+			prepare: keyedPrepare(keyedAccept, keyedActivate),
+			run: func(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+				_, err := (&SmartContract{}).CalculateRedemptionAmount(ctx, contractId, "manager", contractor, "")
+				return err
+			},
+		},
+	)
+}
+
+func newUser(ctx contractapi.TransactionContextInterface, username string) error {
+	return (&SmartContract{}).CreateUserAsset(ctx, username, username, "", "adfc", username+"-account", "INR", "")
+}
+
+// startDate leaves ten daily intervals to redeem
+func startDate() string {
+	return formatDate(time.Now().UTC().AddDate(0, 0, -10))
+}
+
+// setupKeyed gives the manager existingContracts active contracts in the keyed layout
+func setupKeyed(b *testing.B, c *countingStub) {
+	c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+		return newUser(ctx, "manager")
+	})
+	c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+		return newUser(ctx, "existing")
+	})
+	for i := 0; i < existingContracts; i++ {
+		keyedPrepare(keyedAccept, keyedActivate)(b, c, "existing")
+	}
+}
+
+func keyedCreate(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+	return (&SmartContract{}).CreateContractAsset(ctx, "manager", contractor, 365, 1, 100, "benchmark", startDate(), IntervalDays, "", "", false, "")
+}
+
+func keyedAccept(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+	return (&SmartContract{}).AcceptByContractor(ctx, contractId, contractor, "manager")
+}
+
+func keyedActivate(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+	return (&SmartContract{}).AcceptByManager(ctx, contractId, "manager", contractor)
+}
+
+// keyedPrepare creates a contract for the contractor and runs the steps on it, each in its own transaction
+func keyedPrepare(steps ...func(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error) func(b *testing.B, c *countingStub, contractor string) int {
+	return func(b *testing.B, c *countingStub, contractor string) int {
+		c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+			return keyedCreate(ctx, contractor, 0)
+		})
+
+		// The contractor holds no other request, so the new contract is the only one
+		contractId := 0
+		c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+			requests, err := (&SmartContract{}).listContracts(ctx, ListRequests, contractor)
+			if err != nil {
+				return err
+			}
+			contractId = requests[0].ContractId
+			return nil
+		})
+
+		for _, step := range steps {
+			c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+				return step(ctx, contractor, contractId)
+			})
+		}
+		return contractId
+	}
+}
+
+// setupLegacy gives the manager existingContracts active contracts in the user asset, as they were stored before
+func setupLegacy(b *testing.B, c *countingStub) {
+	manager := UserAsset{Username: "manager", Bank: "adfc", BankAccountNo: "manager-account", CentralBankID: "INR"}
+	for i := 1; i <= existingContracts; i++ {
+		manager.Contracts = append(manager.Contracts, legacyContract("existing", i))
+	}
+	c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+		return putLegacyUser(ctx, &manager)
+	})
+}
+
+func legacyContract(contractor string, contractId int) ContractAsset {
+	return ContractAsset{
+		ContractId:           contractId,
+		ContractRef:          "manager-" + strconv.Itoa(contractId),
+		Manager:              "manager",
+		Contractor:           contractor,
+		Duration:             365,
+		Interval:             1,
+		IntervalUnit:         IntervalDays,
+		RatePerInterval:      100,
+		RateCurrency:         "INR",
+		NatureOfWork:         "benchmark",
+		StartDate:            startDate(),
+		LastPaymentDate:      startDate(),
+		ManagerBank:          "adfc",
+		ManagerBankAccountNo: "manager-account",
+		ContractorAccount:    contractor + "-account",
+		PaymentCurrency:      "INR",
+		ContractorBank:       "adfc",
+		TimeZone:             "UTC",
+		ProRate:              ProRateNone,
+		PayBasis:             PayBasisTime,
+	}
+}
+
+func getLegacyUser(ctx contractapi.TransactionContextInterface, username string) (*UserAsset, error) {
+	return (&SmartContract{}).readUserAsset(ctx, username)
+}
+
+func putLegacyUser(ctx contractapi.TransactionContextInterface, userAsset *UserAsset) error {
+	userAssetJSON, err := json.Marshal(userAsset)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(userAsset.Username, userAssetJSON)
+}
+
+// legacyPrepare creates a contract numbered after the existing ones for the contractor and runs the
+// steps on it, each in its own transaction
+func legacyPrepare(steps ...func(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error) func(b *testing.B, c *countingStub, contractor string) int {
+	return func(b *testing.B, c *countingStub, contractor string) int {
+		contractId := existingContracts + c.transaction
+		for _, step := range append([]func(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error{legacyCreate}, steps...) {
+			c.run(b, false, func(ctx contractapi.TransactionContextInterface) error {
+				return step(ctx, contractor, contractId)
+			})
+		}
+		return contractId
+	}
+}
+
+// legacyCreate read both user assets, took a reference from the manager's counter and appended the
+// contract to the requests of the contractor
+func legacyCreate(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+	contractorAsset, err := getLegacyUser(ctx, contractor)
+	if err != nil {
+		return err
+	}
+	if _, err := getLegacyUser(ctx, "manager"); err != nil {
+		return err
+	}
+	if contractId == 0 {
+		if contractId, err = newContractId(ctx, "manager", contractor); err != nil {
+			return err
+		}
+	}
+	if _, err := ctx.GetStub().GetState("contractseq~manager"); err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState("contractseq~manager", []byte(strconv.Itoa(contractId+1))); err != nil {
+		return err
+	}
+
+	contractorAsset.Requests = append(contractorAsset.Requests, legacyContract(contractor, contractId))
+	return putLegacyUser(ctx, contractorAsset)
+}
+
+// legacyAccept moved the contract from the requests of the contractor to the pending contracts of the manager
+func legacyAccept(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+	contractorAsset, err := getLegacyUser(ctx, contractor)
+	if err != nil {
+		return err
+	}
+	managerAsset, err := getLegacyUser(ctx, "manager")
+	if err != nil {
+		return err
+	}
+
+	for i, contract := range contractorAsset.Requests {
+		if contract.ContractId == contractId {
+			contractorAsset.Requests = append(contractorAsset.Requests[:i], contractorAsset.Requests[i+1:]...)
+			managerAsset.Pending = append(managerAsset.Pending, contract)
+			break
+		}
+	}
+
+	if err := putLegacyUser(ctx, contractorAsset); err != nil {
+		return err
+	}
+	return putLegacyUser(ctx, managerAsset)
+}
+
+// legacyActivate moved the contract from the pending contracts of the manager to the contracts of both parties
+func legacyActivate(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+	managerAsset, err := getLegacyUser(ctx, "manager")
+	if err != nil {
+		return err
+	}
+	contractorAsset, err := getLegacyUser(ctx, contractor)
+	if err != nil {
+		return err
+	}
+
+	for i, contract := range managerAsset.Pending {
+		if contract.ContractId == contractId {
+			managerAsset.Pending = append(managerAsset.Pending[:i], managerAsset.Pending[i+1:]...)
+			managerAsset.Contracts = append(managerAsset.Contracts, contract)
+			contractorAsset.Contracts = append(contractorAsset.Contracts, contract)
+			break
+		}
+	}
+
+	if err := putLegacyUser(ctx, managerAsset); err != nil {
+		return err
+	}
+	return putLegacyUser(ctx, contractorAsset)
+}
+
+// legacyRedeem advanced the last payment date of the copies of the contract in both user assets
+func legacyRedeem(ctx contractapi.TransactionContextInterface, contractor string, contractId int) error {
+	managerAsset, err := getLegacyUser(ctx, "manager")
+	if err != nil {
+		return err
+	}
+	contractorAsset, err := getLegacyUser(ctx, contractor)
+	if err != nil {
+		return err
+	}
+
+	today := formatDate(time.Now().UTC())
+	for _, contracts := range [][]ContractAsset{managerAsset.Contracts, contractorAsset.Contracts} {
+		for i := range contracts {
+			if contracts[i].ContractId == contractId {
+				contracts[i].LastPaymentDate = today
+			}
+		}
+	}
+
+	if err := putLegacyUser(ctx, managerAsset); err != nil {
+		return err
+	}
+	return putLegacyUser(ctx, contractorAsset)
+}
@@ -0,0 +1,197 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Contract statuses set by the contractor's response to a request
+const (
+	ContractRejected  = "Rejected"
+	ContractCountered = "Countered"
+)
+
+// CounterTerms are the terms a contractor proposes in place of the manager's. Omitted terms are unchanged.
+type CounterTerms struct {
+	Duration        *int    `json:"duration"`
+	Interval        *int    `json:"interval"`
+	IntervalUnit    *string `json:"intervalUnit"`
+	RatePerInterval *int    `json:"ratePerInterval"`
+	StartDate       *string `json:"startDate"`
+	ProRate         *string `json:"proRate"`
+	AutoRenew       *bool   `json:"autoRenew"`
+	PayBasis        *string `json:"payBasis"`
+}
+
+// ContractDecision records a contractor rejecting or countering a requested contract
+type ContractDecision struct {
+	ContractId int            `json:"contractId"`
+	Manager    string         `json:"manager"`
+	Contractor string         `json:"contractor"`
+	Decision   string         `json:"decision"`
+	Reason     string         `json:"reason"`
+	Terms      *ContractAsset `json:"terms,omitempty" metadata:",optional"` // the revised contract of a counter offer
+	DecidedAt  string         `json:"decidedAt"`
+	TxId       string         `json:"txId"`
+}
+
+// RejectByContractor declines a requested contract, recording the reason and notifying the manager
+func (s *SmartContract) RejectByContractor(ctx contractapi.TransactionContextInterface, contractId int, contractor string, reason string) error {
+	contract, err := s.listed(ctx, ListRequests, contractor, contractId)
+	if err != nil {
+		return err
+	}
+
+	// Remove the contract from the requests of contractor and discard it
+	if err := s.removeFromList(ctx, ListRequests, contractor, contractId); err != nil {
+		return err
+	}
+	if err := s.deleteContract(ctx, contractId); err != nil {
+		return err
+	}
+
+	return s.recordDecision(ctx, "ContractRejected", ContractDecision{
+		ContractId: contractId,
+		Manager:    contract.Manager,
+		Contractor: contractor,
+		Decision:   ContractRejected,
+		Reason:     reason,
+	})
+}
+
+// CounterOffer answers a requested contract with revised terms, given as JSON CounterTerms.
+// The revised contract goes to the manager's pending contracts, where the manager can accept it
+// with AcceptByManager or decline it with RemoveFromPendingOfManager.
+func (s *SmartContract) CounterOffer(ctx contractapi.TransactionContextInterface, contractId int, contractor string, newTerms string) error {
+	var terms CounterTerms
+	decoder := json.NewDecoder(bytes.NewReader([]byte(newTerms)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&terms); err != nil {
+		return fmt.Errorf("failed to parse counter terms: %v", err)
+	}
+
+	listed, err := s.listed(ctx, ListRequests, contractor, contractId)
+	if err != nil {
+		return err
+	}
+	original := *listed
+
+	contract := original
+	if terms.Duration != nil {
+		contract.Duration = *terms.Duration
+	}
+	if terms.Interval != nil {
+		contract.Interval = *terms.Interval
+	}
+	if terms.IntervalUnit != nil {
+		contract.IntervalUnit = *terms.IntervalUnit
+	}
+	if terms.RatePerInterval != nil {
+		contract.RatePerInterval = *terms.RatePerInterval
+	}
+	if terms.StartDate != nil {
+		contract.StartDate = *terms.StartDate
+	}
+	if terms.ProRate != nil {
+		contract.ProRate = *terms.ProRate
+	}
+	if terms.AutoRenew != nil {
+		contract.AutoRenew = *terms.AutoRenew
+	}
+	if terms.PayBasis != nil {
+		contract.PayBasis = *terms.PayBasis
+	}
+	if err := validateTerms(&contract); err != nil {
+		return err
+	}
+	if contract.Duration == original.Duration && contract.Interval == original.Interval &&
+		contract.IntervalUnit == original.IntervalUnit && contract.RatePerInterval == original.RatePerInterval &&
+		contract.StartDate == original.StartDate && contract.ProRate == original.ProRate && contract.AutoRenew == original.AutoRenew &&
+		contract.PayBasis == original.PayBasis {
+		return fmt.Errorf("counter offer does not change the terms of the contract")
+	}
+	contract.LastPaymentDate = contract.StartDate
+	contract.Status = ContractCountered
+
+	// Fill the contractor's payment details as AcceptByContractor does
+	contractorAsset, err := s.userProfile(ctx, contractor)
+	if err != nil {
+		return err
+	}
+	contract.ContractorAccount = contractorAsset.BankAccountNo
+	contract.PaymentCurrency = contractorAsset.CentralBankID
+	contract.ContractorBank = contractorAsset.Bank
+
+	// Move the revised contract from the requests of contractor to the pending contracts of manager
+	if err := s.putContract(ctx, &contract); err != nil {
+		return err
+	}
+	if err := s.removeFromList(ctx, ListRequests, contractor, contractId); err != nil {
+		return err
+	}
+	if err := s.addToList(ctx, ListPending, contract.Manager, contractId); err != nil {
+		return err
+	}
+
+	return s.recordDecision(ctx, "ContractCountered", ContractDecision{
+		ContractId: contractId,
+		Manager:    contract.Manager,
+		Contractor: contractor,
+		Decision:   ContractCountered,
+		Terms:      &contract,
+	})
+}
+
+// recordDecision stores a contractor's decision and emits it as an event for the manager
+func (s *SmartContract) recordDecision(ctx contractapi.TransactionContextInterface, event string, decision ContractDecision) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	decision.DecidedAt = now.Format(time.RFC3339)
+	decision.TxId = ctx.GetStub().GetTxID()
+
+	decisionKey, err := ctx.GetStub().CreateCompositeKey("decision", []string{strconv.Itoa(decision.ContractId), decision.TxId})
+	if err != nil {
+		return err
+	}
+	decisionJSON, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(decisionKey, decisionJSON); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(event, decisionJSON)
+}
+
+// GetContractDecisions lists the contractor's rejections and counter offers for a contract
+func (s *SmartContract) GetContractDecisions(ctx contractapi.TransactionContextInterface, contractId int) ([]ContractDecision, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("decision", []string{strconv.Itoa(contractId)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	decisions := []ContractDecision{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var decision ContractDecision
+		if err := json.Unmarshal(queryResponse.Value, &decision); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
@@ -0,0 +1,210 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Payment split roles
+const (
+	RolePayee        = "payee"
+	RoleIntermediary = "intermediary"
+)
+
+// Payment types the banks withhold tax by
+const (
+	PaymentWages    = "wages"
+	PaymentTransfer = "transfer"
+	PaymentRefund   = "refund"
+)
+
+// Payee is a party that receives a share of each redemption after intermediary margins
+type Payee struct {
+	Username      string  `json:"username"`
+	SharePercent  float64 `json:"sharePercent"`
+	Bank          string  `json:"bank"`
+	BankAccountNo string  `json:"bankAccountNo"`
+	Currency      string  `json:"currency"`
+}
+
+// Intermediary is a party such as a staffing agency that takes a margin of each redemption
+type Intermediary struct {
+	Username      string  `json:"username"`
+	MarginPercent float64 `json:"marginPercent"`
+	Bank          string  `json:"bank"`
+	BankAccountNo string  `json:"bankAccountNo"`
+	Currency      string  `json:"currency"`
+}
+
+// PaymentSplit is one payment the manager's bank makes for a redemption. The amount is in the
+// contract's rate currency and is converted to the party's currency when paid.
+type PaymentSplit struct {
+	Username      string `json:"username"`
+	Role          string `json:"role"`
+	Amount        int    `json:"amount"`
+	Currency      string `json:"currency"`
+	Bank          string `json:"bank"`
+	BankAccountNo string `json:"bankAccountNo"`
+	PaymentType   string `json:"paymentType"` // wages for payees, transfer for margins and escrow, refund for refunds
+}
+
+// SetContractParties sets the payees and intermediaries of a requested contract before the contractor accepts it.
+// payees and intermediaries are JSON arrays of usernames with share and margin percentages; the payee shares must
+// add up to 100. Without payees the contractor receives the whole redemption after intermediary margins.
+func (s *SmartContract) SetContractParties(ctx contractapi.TransactionContextInterface, contractId int, contractor string, payees string, intermediaries string) error {
+	var contractPayees []Payee
+	if payees != "" {
+		if err := json.Unmarshal([]byte(payees), &contractPayees); err != nil {
+			return fmt.Errorf("failed to parse payees: %v", err)
+		}
+	}
+	var contractIntermediaries []Intermediary
+	if intermediaries != "" {
+		if err := json.Unmarshal([]byte(intermediaries), &contractIntermediaries); err != nil {
+			return fmt.Errorf("failed to parse intermediaries: %v", err)
+		}
+	}
+
+	contract, err := s.listed(ctx, ListRequests, contractor, contractId)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	totalShare := 0.0
+	for j := range contractPayees {
+		payee := &contractPayees[j]
+		if seen[payee.Username] {
+			return fmt.Errorf("%s is listed more than once", payee.Username)
+		}
+		seen[payee.Username] = true
+		if payee.SharePercent <= 0 {
+			return fmt.Errorf("share of payee %s must be positive", payee.Username)
+		}
+		totalShare += payee.SharePercent
+
+		// Payment details always come from the payee's own user asset
+		payeeAsset, err := s.userProfile(ctx, payee.Username)
+		if err != nil {
+			return err
+		}
+		payee.Bank = payeeAsset.Bank
+		payee.BankAccountNo = payeeAsset.BankAccountNo
+		payee.Currency = payeeAsset.CentralBankID
+	}
+	if len(contractPayees) > 0 && math.Abs(totalShare-100) > 1e-9 {
+		return fmt.Errorf("payee shares add up to %g%%, not 100%%", totalShare)
+	}
+
+	totalMargin := 0.0
+	for j := range contractIntermediaries {
+		intermediary := &contractIntermediaries[j]
+		if seen[intermediary.Username] {
+			return fmt.Errorf("%s is listed more than once", intermediary.Username)
+		}
+		seen[intermediary.Username] = true
+		if intermediary.MarginPercent <= 0 {
+			return fmt.Errorf("margin of intermediary %s must be positive", intermediary.Username)
+		}
+		totalMargin += intermediary.MarginPercent
+
+		intermediaryAsset, err := s.userProfile(ctx, intermediary.Username)
+		if err != nil {
+			return err
+		}
+		intermediary.Bank = intermediaryAsset.Bank
+		intermediary.BankAccountNo = intermediaryAsset.BankAccountNo
+		intermediary.Currency = intermediaryAsset.CentralBankID
+	}
+	if totalMargin >= 100 {
+		return fmt.Errorf("intermediary margins add up to %g%%, leaving nothing for the payees", totalMargin)
+	}
+
+	contract.Payees = contractPayees
+	contract.Intermediaries = contractIntermediaries
+
+	return s.putContract(ctx, contract)
+}
+
+// paymentSplits fans a redemption out to the contract's parties. Intermediaries take their margins first
+// and the payees share the rest. Rounding remainders go to the first payee, or to the contractor.
+func paymentSplits(contract ContractAsset, amount int) []PaymentSplit {
+	splits := []PaymentSplit{}
+	if amount <= 0 {
+		return splits
+	}
+
+	remaining := amount
+	for _, intermediary := range contract.Intermediaries {
+		margin := int(float64(amount) * intermediary.MarginPercent / 100)
+		remaining -= margin
+		splits = append(splits, PaymentSplit{
+			Username:      intermediary.Username,
+			Role:          RoleIntermediary,
+			Amount:        margin,
+			Currency:      intermediary.Currency,
+			Bank:          intermediary.Bank,
+			BankAccountNo: intermediary.BankAccountNo,
+			PaymentType:   PaymentTransfer,
+		})
+	}
+
+	if len(contract.Payees) == 0 {
+		return append(splits, PaymentSplit{
+			Username:      contract.Contractor,
+			Role:          RolePayee,
+			Amount:        remaining,
+			Currency:      contract.PaymentCurrency,
+			Bank:          contract.ContractorBank,
+			BankAccountNo: contract.ContractorAccount,
+			PaymentType:   PaymentWages,
+		})
+	}
+
+	net := remaining
+	first := len(splits)
+	for _, payee := range contract.Payees {
+		share := int(float64(net) * payee.SharePercent / 100)
+		remaining -= share
+		splits = append(splits, PaymentSplit{
+			Username:      payee.Username,
+			Role:          RolePayee,
+			Amount:        share,
+			Currency:      payee.Currency,
+			Bank:          payee.Bank,
+			BankAccountNo: payee.BankAccountNo,
+			PaymentType:   PaymentWages,
+		})
+	}
+	splits[first].Amount += remaining
+
+	return splits
+}
+
+// GetPaymentSplits returns the payments the manager's bank makes to pay a redeemed amount on a contract
+func (s *SmartContract) GetPaymentSplits(ctx contractapi.TransactionContextInterface, contractId int, manager string, amount int) ([]PaymentSplit, error) {
+	contract, err := s.managedContract(ctx, contractId, manager)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.splitsFor(ctx, *contract, amount)
+}
+
+// managedContract returns an active or completed contract of a manager
+func (s *SmartContract) managedContract(ctx contractapi.TransactionContextInterface, contractId int, manager string) (*ContractAsset, error) {
+	for _, list := range []string{ListContracts, ListCompleted} {
+		in, err := s.inList(ctx, list, manager, contractId)
+		if err != nil {
+			return nil, err
+		}
+		if in {
+			return s.getContract(ctx, contractId)
+		}
+	}
+
+	return nil, fmt.Errorf("contract not found in the contracts of manager")
+}
@@ -0,0 +1,252 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Payroll entry statuses
+const (
+	PayrollRedeemed = "Redeemed"
+	PayrollFailed   = "Failed"
+)
+
+// PayrollEntry is the outcome of redeeming one contract in a payroll run.
+// A redeemed entry carries the payment instruction the manager's bank has to execute.
+type PayrollEntry struct {
+	ContractId           int            `json:"contractId"`
+	Manager              string         `json:"manager"`
+	Contractor           string         `json:"contractor"`
+	Amount               int            `json:"amount"`
+	RateCurrency         string         `json:"rateCurrency"`
+	PaymentCurrency      string         `json:"paymentCurrency"`
+	ManagerBank          string         `json:"managerBank"`
+	ManagerBankAccountNo string         `json:"managerBankAccountNo"`
+	ContractorBank       string         `json:"contractorBank"`
+	ContractorAccount    string         `json:"contractorAccount"`
+	PaidThrough          string         `json:"paidThrough"` // last payment date after this run
+	Completed            bool           `json:"completed"`   // the term ended and this was the final settlement
+	SuccessorId          int            `json:"successorId"` // the renewal created when an auto-renewing contract completed
+	Payments             []PaymentSplit `json:"payments"`    // what the manager's bank pays each party
+	Submissions          []string       `json:"submissions"` // the approved timesheets and deliverables paid
+	Status               string         `json:"status"`
+	Error                string         `json:"error"`
+}
+
+// PayrollRun is the report of redeeming every active contract due as of a date
+type PayrollRun struct {
+	AsOfDate string         `json:"asOfDate"`
+	RunAt    string         `json:"runAt"`
+	TxId     string         `json:"txId"`
+	Entries  []PayrollEntry `json:"entries"`
+	Redeemed int            `json:"redeemed"`
+	Failed   int            `json:"failed"`
+}
+
+// RunPayroll redeems every active contract with approved work or at least one interval completed by the transaction date,
+// taken in each contract's time zone, and completes the contracts whose term has ended. Runs are recorded by their UTC date.
+// asOfDate is normally empty; a date outside the date tolerance is an admin correction and is logged.
+// A contract that cannot be redeemed is reported as failed without blocking the others.
+// The run is recorded, so calling it again for the same date returns the same report without redeeming twice.
+func (s *SmartContract) RunPayroll(ctx contractapi.TransactionContextInterface, asOfDate string) (*PayrollRun, error) {
+	at, err := s.effectiveDate(ctx, "RunPayroll", "payroll", asOfDate)
+	if err != nil {
+		return nil, err
+	}
+	asOfDate = at.String()
+
+	existing, err := s.readPayrollRun(ctx, asOfDate)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	// The contracts are collected first because completed contracts leave the contract lists
+	due, err := s.activeContracts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	run := PayrollRun{
+		AsOfDate: asOfDate,
+		RunAt:    at.at.Format(time.RFC3339),
+		TxId:     ctx.GetStub().GetTxID(),
+		Entries:  []PayrollEntry{},
+	}
+
+	for _, contract := range due {
+		settled, err := redemption(contract, at)
+		var work []Submission
+		if err == nil {
+			var workAmount int
+			work, workAmount, err = s.approvedWork(ctx, contract)
+			settled.amount += workAmount
+		}
+		if err == nil && settled.amount == 0 && !settled.completed {
+			continue
+		}
+
+		entry := PayrollEntry{
+			ContractId:           contract.ContractId,
+			Manager:              contract.Manager,
+			Contractor:           contract.Contractor,
+			RateCurrency:         contract.RateCurrency,
+			PaymentCurrency:      contract.PaymentCurrency,
+			ManagerBank:          contract.ManagerBank,
+			ManagerBankAccountNo: contract.ManagerBankAccountNo,
+			ContractorBank:       contract.ContractorBank,
+			ContractorAccount:    contract.ContractorAccount,
+			PaidThrough:          contract.LastPaymentDate,
+			Payments:             []PaymentSplit{},
+			Submissions:          []string{},
+		}
+
+		contractorListed := false
+		if err == nil {
+			contractorListed, err = s.inList(ctx, ListContracts, contract.Contractor, contract.ContractId)
+		}
+		var payments []PaymentSplit
+		if err == nil {
+			payments, err = s.splitsFor(ctx, contract, settled.amount)
+		}
+
+		switch {
+		case err != nil:
+			entry.Status = PayrollFailed
+			entry.Error = err.Error()
+		case !contractorListed:
+			entry.Status = PayrollFailed
+			entry.Error = "contract not found in the contracts of contractor"
+		default:
+			if err := s.holdInEscrow(ctx, contract, settled.amount); err != nil {
+				return nil, err
+			}
+			if err := s.markPaid(ctx, work, run.RunAt); err != nil {
+				return nil, err
+			}
+			contract.LastPaymentDate = settled.paidThrough
+			if settled.completed {
+				successorId, successorRef, err := successorOf(ctx, contract)
+				if err != nil {
+					return nil, err
+				}
+				if err := s.completeContract(ctx, &contract, run.RunAt, successorId, successorRef); err != nil {
+					return nil, err
+				}
+				entry.Completed = true
+				entry.SuccessorId = successorId
+			} else if err := s.putContract(ctx, &contract); err != nil {
+				return nil, err
+			}
+
+			entry.Amount = settled.amount
+			entry.Payments = payments
+			for _, submission := range work {
+				entry.Submissions = append(entry.Submissions, submission.SubmissionId)
+			}
+			entry.PaidThrough = settled.paidThrough
+			entry.Status = PayrollRedeemed
+		}
+
+		if entry.Status == PayrollRedeemed {
+			run.Redeemed++
+		} else {
+			run.Failed++
+		}
+		run.Entries = append(run.Entries, entry)
+	}
+
+	runKey, err := ctx.GetStub().CreateCompositeKey("payroll", []string{asOfDate})
+	if err != nil {
+		return nil, err
+	}
+	runJSON, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(runKey, runJSON); err != nil {
+		return nil, err
+	}
+
+	return &run, ctx.GetStub().SetEvent("PayrollRun", runJSON)
+}
+
+// GetPayrollRun retrieves the report of the payroll run for a date
+func (s *SmartContract) GetPayrollRun(ctx contractapi.TransactionContextInterface, asOfDate string) (*PayrollRun, error) {
+	date, err := parseDate(asOfDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse as of date: %v", err)
+	}
+	run, err := s.readPayrollRun(ctx, formatDate(date))
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, fmt.Errorf("no payroll has been run for %s", asOfDate)
+	}
+
+	return run, nil
+}
+
+func (s *SmartContract) readPayrollRun(ctx contractapi.TransactionContextInterface, asOfDate string) (*PayrollRun, error) {
+	runKey, err := ctx.GetStub().CreateCompositeKey("payroll", []string{asOfDate})
+	if err != nil {
+		return nil, err
+	}
+	runJSON, err := ctx.GetStub().GetState(runKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payroll run from world state: %v", err)
+	}
+	if runJSON == nil {
+		return nil, nil
+	}
+
+	var run PayrollRun
+	if err := json.Unmarshal(runJSON, &run); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// activeContracts collects every active contract once, from the contract lists of their managers
+func (s *SmartContract) activeContracts(ctx contractapi.TransactionContextInterface) ([]ContractAsset, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("usercontract", []string{ListContracts})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	contracts := []ContractAsset{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		contractId, err := strconv.Atoi(attributes[2])
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert contract id to int: %v", err)
+		}
+
+		contract, err := s.getContract(ctx, contractId)
+		if err != nil {
+			return nil, err
+		}
+		// Both parties list the contract; it is paid once, for its manager
+		if contract.Manager == attributes[1] {
+			contracts = append(contracts, *contract)
+		}
+	}
+
+	return contracts, nil
+}
@@ -0,0 +1,338 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultBankChannel is the channel the bank chaincodes are deployed on unless SetBankChannel changes it
+const defaultBankChannel = "bank"
+
+// Account change statuses
+const (
+	AccountChangePending  = "Pending"
+	AccountChangeApproved = "Approved"
+	AccountChangeRejected = "Rejected"
+)
+
+// AccountChange is a party's request to have a contract pay to or from the bank account now in their profile.
+// It takes effect once the counterparty approves it: the contractor for the manager's account and
+// the manager for everyone else's.
+type AccountChange struct {
+	ContractId    int    `json:"contractId"`
+	Username      string `json:"username"`
+	Approver      string `json:"approver"`
+	Bank          string `json:"bank"`
+	BankAccountNo string `json:"bankAccountNo"`
+	Currency      string `json:"currency"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason"` // why the approver rejected it
+	RequestedAt   string `json:"requestedAt"`
+	DecidedAt     string `json:"decidedAt"`
+}
+
+// SetBankChannel sets the channel on which bank accounts are verified
+func (s *SmartContract) SetBankChannel(ctx contractapi.TransactionContextInterface, channel string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	channelKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"bankchannel"})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(channelKey, []byte(channel))
+}
+
+// UpdateUserProfile changes a user's details and bank account. A new bank account must exist at the named bank,
+// be owned by the user and hold the currency of the named central bank. Existing contracts keep paying the
+// old account; with repointContracts set, an account change is requested on each of the user's active contracts.
+func (s *SmartContract) UpdateUserProfile(ctx contractapi.TransactionContextInterface, username string, password string, name string, bank string, bankAccountNo string, centralBankID string, company string, repointContracts bool) error {
+	userAsset, err := s.userProfile(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(userAsset.Password), []byte(password)); err != nil {
+		return fmt.Errorf("incorrect password for %s", username)
+	}
+
+	accountChanged := !strings.EqualFold(bank, userAsset.Bank) || bankAccountNo != userAsset.BankAccountNo || !strings.EqualFold(centralBankID, userAsset.CentralBankID)
+	if accountChanged {
+		if err := s.verifyBankAccount(ctx, bank, bankAccountNo, username, centralBankID); err != nil {
+			return err
+		}
+	}
+
+	userAsset.Name = name
+	userAsset.Bank = bank
+	userAsset.BankAccountNo = bankAccountNo
+	userAsset.CentralBankID = centralBankID
+	userAsset.Company = company
+	if err := s.putUserProfile(ctx, userAsset); err != nil {
+		return err
+	}
+
+	if !repointContracts {
+		return nil
+	}
+
+	contracts, err := s.listContracts(ctx, ListContracts, username)
+	if err != nil {
+		return err
+	}
+	for i := range contracts {
+		if err := s.requestAccountChange(ctx, &contracts[i], userAsset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RequestAccountChange asks the counterparty of an active contract to move a party's payments
+// to the bank account now in the party's profile
+func (s *SmartContract) RequestAccountChange(ctx contractapi.TransactionContextInterface, contractId int, username string) error {
+	contract, err := s.getContract(ctx, contractId)
+	if err != nil {
+		return err
+	}
+	// An empty status on legacy contracts means active
+	if contract.Status != "" && contract.Status != ContractActive {
+		return fmt.Errorf("contract %d is not active", contractId)
+	}
+	userAsset, err := s.userProfile(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	return s.requestAccountChange(ctx, contract, userAsset)
+}
+
+// ApproveAccountChange applies a party's requested account change to a contract
+func (s *SmartContract) ApproveAccountChange(ctx contractapi.TransactionContextInterface, contractId int, username string, approver string) error {
+	change, err := s.pendingAccountChange(ctx, contractId, username, approver)
+	if err != nil {
+		return err
+	}
+	contract, err := s.listed(ctx, ListContracts, approver, contractId)
+	if err != nil {
+		return err
+	}
+
+	if username == contract.Manager {
+		contract.ManagerBank = change.Bank
+		contract.ManagerBankAccountNo = change.BankAccountNo
+	}
+	if username == contract.Contractor {
+		contract.ContractorBank = change.Bank
+		contract.ContractorAccount = change.BankAccountNo
+		contract.PaymentCurrency = change.Currency
+	}
+	for i := range contract.Payees {
+		if contract.Payees[i].Username == username {
+			contract.Payees[i].Bank = change.Bank
+			contract.Payees[i].BankAccountNo = change.BankAccountNo
+			contract.Payees[i].Currency = change.Currency
+		}
+	}
+	for i := range contract.Intermediaries {
+		if contract.Intermediaries[i].Username == username {
+			contract.Intermediaries[i].Bank = change.Bank
+			contract.Intermediaries[i].BankAccountNo = change.BankAccountNo
+			contract.Intermediaries[i].Currency = change.Currency
+		}
+	}
+	if err := s.putContract(ctx, contract); err != nil {
+		return err
+	}
+
+	return s.decideAccountChange(ctx, change, AccountChangeApproved, "")
+}
+
+// RejectAccountChange declines a party's requested account change with a reason
+func (s *SmartContract) RejectAccountChange(ctx contractapi.TransactionContextInterface, contractId int, username string, approver string, reason string) error {
+	change, err := s.pendingAccountChange(ctx, contractId, username, approver)
+	if err != nil {
+		return err
+	}
+
+	return s.decideAccountChange(ctx, change, AccountChangeRejected, reason)
+}
+
+// GetAccountChange returns the latest account change a party requested on a contract
+func (s *SmartContract) GetAccountChange(ctx contractapi.TransactionContextInterface, contractId int, username string) (*AccountChange, error) {
+	changeKey, err := ctx.GetStub().CreateCompositeKey("accountchange", []string{strconv.Itoa(contractId), username})
+	if err != nil {
+		return nil, err
+	}
+	changeJSON, err := ctx.GetStub().GetState(changeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account change from world state: %v", err)
+	}
+	if changeJSON == nil {
+		return nil, fmt.Errorf("%s has not requested an account change on contract %d", username, contractId)
+	}
+
+	var change AccountChange
+	if err := json.Unmarshal(changeJSON, &change); err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+// requestAccountChange records a pending change of a party's account on a contract to their profile's account,
+// replacing any earlier request. Contracts already paying that account are left alone.
+func (s *SmartContract) requestAccountChange(ctx contractapi.TransactionContextInterface, contract *ContractAsset, userAsset *UserAsset) error {
+	username := userAsset.Username
+	current := ""
+	approver := contract.Manager
+	switch username {
+	case contract.Manager:
+		current = contract.ManagerBank + "/" + contract.ManagerBankAccountNo
+		approver = contract.Contractor
+	case contract.Contractor:
+		current = contract.ContractorBank + "/" + contract.ContractorAccount
+	default:
+		for _, payee := range contract.Payees {
+			if payee.Username == username {
+				current = payee.Bank + "/" + payee.BankAccountNo
+			}
+		}
+		for _, intermediary := range contract.Intermediaries {
+			if intermediary.Username == username {
+				current = intermediary.Bank + "/" + intermediary.BankAccountNo
+			}
+		}
+		if current == "" {
+			return fmt.Errorf("%s is not a party to contract %d", username, contract.ContractId)
+		}
+	}
+	if strings.EqualFold(current, userAsset.Bank+"/"+userAsset.BankAccountNo) {
+		return nil
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.putAccountChange(ctx, "AccountChangeRequested", &AccountChange{
+		ContractId:    contract.ContractId,
+		Username:      username,
+		Approver:      approver,
+		Bank:          userAsset.Bank,
+		BankAccountNo: userAsset.BankAccountNo,
+		Currency:      userAsset.CentralBankID,
+		Status:        AccountChangePending,
+		RequestedAt:   now.Format(time.RFC3339),
+	})
+}
+
+// pendingAccountChange returns a party's pending account change if the approver may decide it
+func (s *SmartContract) pendingAccountChange(ctx contractapi.TransactionContextInterface, contractId int, username string, approver string) (*AccountChange, error) {
+	change, err := s.GetAccountChange(ctx, contractId, username)
+	if err != nil {
+		return nil, err
+	}
+	if change.Status != AccountChangePending {
+		return nil, fmt.Errorf("account change of %s on contract %d is already %s", username, contractId, change.Status)
+	}
+	if change.Approver != approver {
+		return nil, fmt.Errorf("only %s can approve or reject the account change of %s", change.Approver, username)
+	}
+
+	return change, nil
+}
+
+func (s *SmartContract) decideAccountChange(ctx contractapi.TransactionContextInterface, change *AccountChange, decision string, reason string) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	change.Status = decision
+	change.Reason = reason
+	change.DecidedAt = now.Format(time.RFC3339)
+
+	return s.putAccountChange(ctx, "AccountChange"+decision, change)
+}
+
+// putAccountChange stores an account change and emits it as an event
+func (s *SmartContract) putAccountChange(ctx contractapi.TransactionContextInterface, event string, change *AccountChange) error {
+	changeKey, err := ctx.GetStub().CreateCompositeKey("accountchange", []string{strconv.Itoa(change.ContractId), change.Username})
+	if err != nil {
+		return err
+	}
+	changeJSON, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(changeKey, changeJSON); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(event, changeJSON)
+}
+
+// verifyBankAccount queries the bank's chaincode for an account and checks that it is open, owned by the user
+// and held at the central bank of the given currency
+func (s *SmartContract) verifyBankAccount(ctx contractapi.TransactionContextInterface, bank string, bankAccountNo string, username string, centralBankID string) error {
+	channel, err := s.bankChannel(ctx)
+	if err != nil {
+		return err
+	}
+
+	response := ctx.GetStub().InvokeChaincode(strings.ToLower(bank), [][]byte{[]byte("GetAccountOwner"), []byte(bankAccountNo)}, channel)
+	if response.GetStatus() != 200 {
+		return fmt.Errorf("%s chaincode account owner query returned %d. %s", bank, response.GetStatus(), response.GetMessage())
+	}
+	owner := string(response.GetPayload())
+	if owner == "" {
+		return fmt.Errorf("bank account %s does not exist at %s", bankAccountNo, bank)
+	}
+	if owner != username {
+		return fmt.Errorf("bank account %s at %s does not belong to %s", bankAccountNo, bank, username)
+	}
+
+	response = ctx.GetStub().InvokeChaincode(strings.ToLower(bank), [][]byte{[]byte("GetBankAccountAsset"), []byte(bankAccountNo)}, channel)
+	if response.GetStatus() != 200 {
+		return fmt.Errorf("%s chaincode account query returned %d. %s", bank, response.GetStatus(), response.GetMessage())
+	}
+	var account struct {
+		CentralBank string `json:"centralBank"`
+		Status      string `json:"status"`
+	}
+	if err := json.Unmarshal(response.GetPayload(), &account); err != nil {
+		return fmt.Errorf("failed to parse bank account %s: %v", bankAccountNo, err)
+	}
+	if account.Status == "Closed" {
+		return fmt.Errorf("bank account %s at %s is closed", bankAccountNo, bank)
+	}
+	if !strings.EqualFold(account.CentralBank, centralBankID) {
+		return fmt.Errorf("bank account %s at %s is held in %s, not %s", bankAccountNo, bank, account.CentralBank, centralBankID)
+	}
+
+	return nil
+}
+
+func (s *SmartContract) bankChannel(ctx contractapi.TransactionContextInterface) (string, error) {
+	channelKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"bankchannel"})
+	if err != nil {
+		return "", err
+	}
+	channel, err := ctx.GetStub().GetState(channelKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bank channel from world state: %v", err)
+	}
+	if channel == nil {
+		return defaultBankChannel, nil
+	}
+
+	return string(channel), nil
+}
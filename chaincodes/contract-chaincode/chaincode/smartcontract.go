@@ -20,6 +20,7 @@ type UserAsset struct {
 	Contracts     []ContractAsset `json:"contracts"`
 	Requests      []ContractAsset `json:"requests"`
 	Pending       []ContractAsset `json:"pending"`
+	Completed     []ContractAsset `json:"completed,omitempty" metadata:",optional"`
 	Username      string          `json:"username"` // Unique primary key
 	Name          string          `json:"name"`
 	Password      string          `json:"password"`
@@ -27,6 +28,7 @@ type UserAsset struct {
 	BankAccountNo string          `json:"bankAccountNo"`
 	CentralBankID string          `json:"centralBankID"`
 	Company       string          `json:"company"`
+	PublicKey     string          `json:"publicKey"` // PEM ECDSA key the user signs contract documents with
 }
 
 // // BankAccountAsset represents a bank account asset
@@ -39,65 +41,94 @@ type UserAsset struct {
 
 // ContractAsset represents a contract asset
 type ContractAsset struct {
-	ContractId           int    `json:"contractId"`
-	Manager              string `json:"manager"`
-	Contractor           string `json:"contractor"`
-	Duration             int    `json:"duration"`
-	Interval             int    `json:"interval"`
-	RatePerInterval      int    `json:"ratePerInterval"`
-	RateCurrency         string `json:"rateCurrency"`
-	NatureOfWork         string `json:"natureOfWork"`
-	StartDate            string `json:"startDate"`
-	LastPaymentDate      string `json:"lastPaymentDate"`
-	ManagerBank          string `json:"managerBank"`
-	ManagerBankAccountNo string `json:"managerBankAccountNo"`
-	ContractorAccount    string `json:"contractorAccount"`
-	PaymentCurrency      string `json:"paymentCurrency"`
-	ContractorBank       string `json:"contractorBank"`
+	ContractId           int            `json:"contractId"`
+	ContractRef          string         `json:"contractRef"` // human-friendly reference, such as manager-20240131-2D4QX7B1K9
+	Manager              string         `json:"manager"`
+	Contractor           string         `json:"contractor"`
+	Duration             int            `json:"duration"`
+	Interval             int            `json:"interval"`
+	IntervalUnit         string         `json:"intervalUnit"` // days, weeks or months; empty on legacy contracts means days
+	RatePerInterval      int            `json:"ratePerInterval"`
+	RateCurrency         string         `json:"rateCurrency"`
+	NatureOfWork         string         `json:"natureOfWork"`
+	StartDate            string         `json:"startDate"`
+	LastPaymentDate      string         `json:"lastPaymentDate"`
+	ManagerBank          string         `json:"managerBank"`
+	ManagerBankAccountNo string         `json:"managerBankAccountNo"`
+	ContractorAccount    string         `json:"contractorAccount"`
+	PaymentCurrency      string         `json:"paymentCurrency"`
+	ContractorBank       string         `json:"contractorBank"`
+	TimeZone             string         `json:"timeZone"` // IANA time zone that decides when a day ends; empty means UTC
+	Status               string         `json:"status"`   // empty on legacy contracts means Active
+	ProRate              string         `json:"proRate"`  // how a final partial interval is paid; empty means none
+	AutoRenew            bool           `json:"autoRenew"`
+	PredecessorId        int            `json:"predecessorId"` // the contract this one renewed, if any
+	SuccessorId          int            `json:"successorId"`   // the contract that renewed this one, if any
+	CompletedAt          string         `json:"completedAt"`
+	Payees               []Payee        `json:"payees,omitempty" metadata:",optional"`         // share each redemption; empty means the contractor alone
+	Intermediaries       []Intermediary `json:"intermediaries,omitempty" metadata:",optional"` // take a margin of each redemption
+	DisputeId            string         `json:"disputeId"`                                     // the open dispute holding payments in escrow, if any
+	PayBasis             string         `json:"payBasis"`                                      // time, hourly or milestone; empty means time
+	DocumentHash         string         `json:"documentHash"`                                  // SHA-256 of the signed agreement, if any
 }
 
 // InitLedger initializes the ledger with sample assets
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	// Initialize any necessary data here
-
-	return ctx.GetStub().PutState("contractNo", []byte("1"))
-}
-
-func (s *SmartContract) GetContractNo(ctx contractapi.TransactionContextInterface) (int, error) {
-	contractNoBytes, err := ctx.GetStub().GetState("contractNo")
+	// The first identity to initialize the ledger becomes the contract admin
+	adminKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"admin"})
 	if err != nil {
-		return 0, fmt.Errorf("failed to read contractNo from world state: %v", err)
-	}
-	if contractNoBytes == nil {
-		return 0, fmt.Errorf("contractNo does not exist")
+		return err
 	}
-
-	contractNo, err := strconv.Atoi(string(contractNoBytes))
+	adminBytes, err := ctx.GetStub().GetState(adminKey)
 	if err != nil {
-		return 0, fmt.Errorf("failed to convert contractNo to int: %v", err)
+		return fmt.Errorf("failed to read admin from world state: %v", err)
+	}
+	if adminBytes == nil {
+		clientID, err := ctx.GetClientIdentity().GetID()
+		if err != nil {
+			return fmt.Errorf("failed to get client identity: %v", err)
+		}
+		if err := ctx.GetStub().PutState(adminKey, []byte(clientID)); err != nil {
+			return err
+		}
 	}
 
-	return contractNo, nil
+	return nil
 }
 
-func (s *SmartContract) IncrementContractNo(ctx contractapi.TransactionContextInterface) error {
-	contractNoBytes, err := ctx.GetStub().GetState("contractNo")
+// requireAdmin returns an error unless the caller is the contract admin
+func (s *SmartContract) requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	adminKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"admin"})
 	if err != nil {
-		return fmt.Errorf("failed to read contractNo from world state: %v", err)
+		return err
 	}
-	if contractNoBytes == nil {
-		return fmt.Errorf("contractNo does not exist")
+	adminBytes, err := ctx.GetStub().GetState(adminKey)
+	if err != nil {
+		return fmt.Errorf("failed to read admin from world state: %v", err)
+	}
+	if adminBytes == nil {
+		return fmt.Errorf("contract admin is not set, run InitLedger first")
 	}
 
-	contractNo, err := strconv.Atoi(string(contractNoBytes))
+	clientID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return fmt.Errorf("failed to convert contractNo to int: %v", err)
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if clientID != string(adminBytes) {
+		return fmt.Errorf("caller is not the contract admin")
 	}
 
-	contractNo++
-	contractNoBytes = []byte(strconv.Itoa(contractNo))
+	return nil
+}
 
-	return ctx.GetStub().PutState("contractNo", contractNoBytes)
+// txTime returns the transaction timestamp, which is identical on every endorsing peer
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	return timestamp.AsTime().UTC(), nil
 }
 
 // CreateUserAsset creates a new user asset
@@ -114,6 +145,7 @@ func (s *SmartContract) CreateUserAsset(ctx contractapi.TransactionContextInterf
 		Contracts:     []ContractAsset{},
 		Requests:      []ContractAsset{},
 		Pending:       []ContractAsset{},
+		Completed:     []ContractAsset{},
 		Username:      username,
 		Name:          name,
 		Password:      password,
@@ -152,33 +184,47 @@ func (s *SmartContract) VerifyUserAsset(ctx contractapi.TransactionContextInterf
 	return err == nil, nil
 }
 
-// GetUserAsset retrieves a user asset by username
+// GetUserAsset retrieves a user asset by username, with its contract lists
 func (s *SmartContract) GetUserAsset(ctx contractapi.TransactionContextInterface, username string) (*UserAsset, error) {
-	userAssetJSON, err := ctx.GetStub().GetState(username)
+	userAsset, err := s.readUserAsset(ctx, username)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read user asset from world state: %v", err)
+		return nil, err
 	}
-	if userAssetJSON == nil {
-		return nil, fmt.Errorf("user asset with username %s does not exist", username)
+
+	// A user asset that has not been migrated still holds its contracts
+	if len(userAsset.Contracts)+len(userAsset.Requests)+len(userAsset.Pending)+len(userAsset.Completed) > 0 {
+		if userAsset.Completed == nil {
+			userAsset.Completed = []ContractAsset{}
+		}
+		return userAsset, nil
 	}
 
-	var userAsset UserAsset
-	err = json.Unmarshal(userAssetJSON, &userAsset)
-	if err != nil {
+	if userAsset.Requests, err = s.listContracts(ctx, ListRequests, username); err != nil {
+		return nil, err
+	}
+	if userAsset.Pending, err = s.listContracts(ctx, ListPending, username); err != nil {
+		return nil, err
+	}
+	if userAsset.Contracts, err = s.listContracts(ctx, ListContracts, username); err != nil {
+		return nil, err
+	}
+	if userAsset.Completed, err = s.listContracts(ctx, ListCompleted, username); err != nil {
 		return nil, err
 	}
 
-	return &userAsset, nil
+	return userAsset, nil
 }
 
-// CreateContractAsset creates a new contract asset and adds it to the user's asset
-func (s *SmartContract) CreateContractAsset(ctx contractapi.TransactionContextInterface, manager string, contractor string, duration int, interval int, ratePerInterval int, natureOfWork string, startDate string) error {
-	contractorAsset, err := s.GetUserAsset(ctx, contractor)
-	if err != nil {
+// CreateContractAsset proposes a contract to a contractor. The duration is in days and the interval
+// in intervalUnit; startDate is an ISO-8601 date in the contract's time zone. proRate decides whether a
+// final partial interval is paid, and an auto-renewing contract is succeeded by one on the same terms when it ends.
+// payBasis decides whether the rate is paid per interval, or per approved hour or deliverable.
+func (s *SmartContract) CreateContractAsset(ctx contractapi.TransactionContextInterface, manager string, contractor string, duration int, interval int, ratePerInterval int, natureOfWork string, startDate string, intervalUnit string, timeZone string, proRate string, autoRenew bool, payBasis string) error {
+	if _, err := s.userProfile(ctx, contractor); err != nil {
 		return err
 	}
 
-	managerAsset, err := s.GetUserAsset(ctx, manager)
+	managerAsset, err := s.userProfile(ctx, manager)
 	if err != nil {
 		return err
 	}
@@ -187,167 +233,148 @@ func (s *SmartContract) CreateContractAsset(ctx contractapi.TransactionContextIn
 	managerBank := managerAsset.Bank
 	managerBankAccountNo := managerAsset.BankAccountNo
 
-	contractNo, err := s.GetContractNo(ctx)
+	contractId, err := newContractId(ctx, manager, contractor)
 	if err != nil {
-		return fmt.Errorf("failed to read contractNo from world state: %v", err)
+		return err
+	}
+	ref, err := contractRef(ctx, manager, contractId)
+	if err != nil {
+		return err
 	}
 
 	// Create the contract asset
 	contract := ContractAsset{
-		ContractId:           contractNo,
+		ContractId:           contractId,
+		ContractRef:          ref,
 		Manager:              manager,
 		Contractor:           contractor,
 		Duration:             duration,
 		Interval:             interval,
+		IntervalUnit:         intervalUnit,
 		RatePerInterval:      ratePerInterval,
 		RateCurrency:         rateCurrency,
 		NatureOfWork:         natureOfWork,
 		StartDate:            startDate,
-		LastPaymentDate:      startDate,
 		ManagerBank:          managerBank,
 		ManagerBankAccountNo: managerBankAccountNo,
 		ContractorAccount:    "",
 		PaymentCurrency:      "",
 		ContractorBank:       "",
+		TimeZone:             timeZone,
+		ProRate:              proRate,
+		AutoRenew:            autoRenew,
+		PayBasis:             payBasis,
 	}
+	if err := validateTerms(&contract); err != nil {
+		return err
+	}
+	contract.LastPaymentDate = contract.StartDate
 
-	// Increment the contract number
-	if err := s.IncrementContractNo(ctx); err != nil {
+	// Store the contract and add it to the requests of the contractor
+	if err := s.putContract(ctx, &contract); err != nil {
 		return err
 	}
 
-	// Append the contract to the Requests array of the user
-	contractorAsset.Requests = append(contractorAsset.Requests, contract)
+	return s.addToList(ctx, ListRequests, contractor, contract.ContractId)
+}
 
-	// Marshal the updated user asset
-	userAssetJSON, err := json.Marshal(contractorAsset)
+// validateTerms checks the terms of a proposed contract, filling in defaults and normalizing the start date
+func validateTerms(contract *ContractAsset) error {
+	if contract.Duration <= 0 || contract.Interval <= 0 {
+		return fmt.Errorf("duration and interval must be positive")
+	}
+	if contract.RatePerInterval < 0 {
+		return fmt.Errorf("rate per interval cannot be negative")
+	}
+	if contract.IntervalUnit == "" {
+		contract.IntervalUnit = IntervalDays
+	}
+	if !validIntervalUnit(contract.IntervalUnit) {
+		return fmt.Errorf("interval unit must be %s, %s or %s", IntervalDays, IntervalWeeks, IntervalMonths)
+	}
+	if contract.TimeZone == "" {
+		contract.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(contract.TimeZone); err != nil {
+		return fmt.Errorf("failed to load time zone: %v", err)
+	}
+	if contract.ProRate == "" {
+		contract.ProRate = ProRateNone
+	}
+	if contract.ProRate != ProRateNone && contract.ProRate != ProRateDaily {
+		return fmt.Errorf("pro-rating rule must be %s or %s", ProRateNone, ProRateDaily)
+	}
+	if contract.PayBasis == "" {
+		contract.PayBasis = PayBasisTime
+	}
+	if contract.PayBasis != PayBasisTime && !paidPerSubmission(*contract) {
+		return fmt.Errorf("pay basis must be %s, %s or %s", PayBasisTime, PayBasisHourly, PayBasisMilestone)
+	}
+	start, err := parseDate(contract.StartDate)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to parse start date: %v", err)
 	}
+	contract.StartDate = formatDate(start)
 
-	// Put the updated user asset back to the world state
-	return ctx.GetStub().PutState(contractor, userAssetJSON)
+	return nil
 }
 
 // AcceptByContractor fills ContractorAccount and PaymentCurrency in the previous contract,
-// removes it from the Requests array of contractor,
-// and appends it to the Pending array of manager
+// removes it from the requests of contractor,
+// and adds it to the pending contracts of manager
 func (s *SmartContract) AcceptByContractor(ctx contractapi.TransactionContextInterface, contractId int, contractor string, manager string) error {
-	// Get contractor's user asset
-	contractorAsset, err := s.GetUserAsset(ctx, contractor)
+	// Find the contract in the requests of the contractor
+	contract, err := s.listed(ctx, ListRequests, contractor, contractId)
 	if err != nil {
 		return err
 	}
 
-	// Find the contract in the Requests array of the contractor
-	var contractIndex int
-	var found bool
-	for i, contract := range contractorAsset.Requests {
-		if contract.ContractId == contractId {
-			contractIndex = i
-			found = true
-			break
-		}
-	}
-	if !found {
-		return fmt.Errorf("contract not found in the requests of contractor")
+	// Get contractor's user asset
+	contractorAsset, err := s.userProfile(ctx, contractor)
+	if err != nil {
+		return err
 	}
 
 	// Fill ContractorAccount and PaymentCurrency in the previous contract
-	contract := contractorAsset.Requests[contractIndex]
 	contract.ContractorAccount = contractorAsset.BankAccountNo
 	contract.PaymentCurrency = contractorAsset.CentralBankID
 	contract.ContractorBank = contractorAsset.Bank
-
-	// Remove the contract from the Requests array of contractor
-	contractorAsset.Requests = append(contractorAsset.Requests[:contractIndex], contractorAsset.Requests[contractIndex+1:]...)
-
-	// Get manager's user asset
-	managerAsset, err := s.GetUserAsset(ctx, manager)
-	if err != nil {
+	if err := s.putContract(ctx, contract); err != nil {
 		return err
 	}
 
-	// Append the modified contract to the Pending array of manager
-	managerAsset.Pending = append(managerAsset.Pending, contract)
-
-	// Update contractor's user asset in the world state
-	contractorAssetJSON, err := json.Marshal(contractorAsset)
-	if err != nil {
-		return err
-	}
-	if err := ctx.GetStub().PutState(contractor, contractorAssetJSON); err != nil {
+	// Move the contract from the requests of contractor to the pending contracts of manager
+	if err := s.removeFromList(ctx, ListRequests, contractor, contractId); err != nil {
 		return err
 	}
 
-	// Update manager's user asset in the world state
-	managerAssetJSON, err := json.Marshal(managerAsset)
-	if err != nil {
-		return err
-	}
-	if err := ctx.GetStub().PutState(manager, managerAssetJSON); err != nil {
-		return err
-	}
-
-	return nil
+	return s.addToList(ctx, ListPending, manager, contractId)
 }
 
-// AcceptByManager appends the final contract to the Contracts array of both contractor and manager
-// and removes it from the Pending array of manager
+// AcceptByManager adds the final contract to the contracts of both contractor and manager
+// and removes it from the pending contracts of manager
 func (s *SmartContract) AcceptByManager(ctx contractapi.TransactionContextInterface, contractId int, manager string, contractor string) error {
-	// Get manager's user asset
-	managerAsset, err := s.GetUserAsset(ctx, manager)
-	if err != nil {
-		return err
-	}
-
-	// Find the contract in the Pending array of manager
-	var contractIndex int
-	var found bool
-	for i, contract := range managerAsset.Pending {
-		if contract.ContractId == contractId {
-			contractIndex = i
-			found = true
-			break
-		}
-	}
-	if !found {
-		return fmt.Errorf("contract not found in the pending of manager")
-	}
-
-	// Get contractor's user asset
-	contractorAsset, err := s.GetUserAsset(ctx, contractor)
+	// Find the contract in the pending contracts of manager
+	contract, err := s.listed(ctx, ListPending, manager, contractId)
 	if err != nil {
 		return err
 	}
 
-	// Append the contract to the Contracts array of both contractor and manager
-	contract := managerAsset.Pending[contractIndex]
-	managerAsset.Contracts = append(managerAsset.Contracts, contract)
-	contractorAsset.Contracts = append(contractorAsset.Contracts, contract)
-
-	// Remove the contract from the Pending array of manager
-	managerAsset.Pending = append(managerAsset.Pending[:contractIndex], managerAsset.Pending[contractIndex+1:]...)
-
-	// Update manager's user asset in the world state
-	managerAssetJSON, err := json.Marshal(managerAsset)
-	if err != nil {
-		return err
-	}
-	if err := ctx.GetStub().PutState(manager, managerAssetJSON); err != nil {
+	contract.Status = ContractActive
+	if err := s.putContract(ctx, contract); err != nil {
 		return err
 	}
 
-	// Update contractor's user asset in the world state
-	contractorAssetJSON, err := json.Marshal(contractorAsset)
-	if err != nil {
+	// Add the contract to the contracts of both contractor and manager
+	if err := s.addToList(ctx, ListContracts, manager, contractId); err != nil {
 		return err
 	}
-	if err := ctx.GetStub().PutState(contractor, contractorAssetJSON); err != nil {
+	if err := s.addToList(ctx, ListContracts, contractor, contractId); err != nil {
 		return err
 	}
 
-	return nil
+	// Remove the contract from the pending contracts of manager
+	return s.removeFromList(ctx, ListPending, manager, contractId)
 }
 
 // UserAssetExists checks if a user asset exists in the world state
@@ -388,259 +415,206 @@ func (s *SmartContract) GetContracts(ctx contractapi.TransactionContextInterface
 }
 
 func (s *SmartContract) RemoveFromRequestedOfContractor(ctx contractapi.TransactionContextInterface, contractId int, contractor string) error {
-	// Get contractor's user asset
-	contractorAsset, err := s.GetUserAsset(ctx, contractor)
-	if err != nil {
+	// Find the contract in the requests of the contractor
+	if _, err := s.listed(ctx, ListRequests, contractor, contractId); err != nil {
 		return err
 	}
 
-	// Find the contract in the Requests array of the contractor
-	var contractIndex int
-	var found bool
-	for i, contract := range contractorAsset.Requests {
-		if contract.ContractId == contractId {
-			contractIndex = i
-			found = true
-			break
-		}
-	}
-	if !found {
-		return fmt.Errorf("contract not found in the requests of contractor")
-	}
-
-	// Remove the contract from the Requests array of contractor
-	contractorAsset.Requests = append(contractorAsset.Requests[:contractIndex], contractorAsset.Requests[contractIndex+1:]...)
-
-	// Update contractor's user asset in the world state
-	contractorAssetJSON, err := json.Marshal(contractorAsset)
-	if err != nil {
-		return err
-	}
-	if err := ctx.GetStub().PutState(contractor, contractorAssetJSON); err != nil {
+	// Remove the contract from the requests of contractor and discard it
+	if err := s.removeFromList(ctx, ListRequests, contractor, contractId); err != nil {
 		return err
 	}
 
-	return nil
+	return s.deleteContract(ctx, contractId)
 }
 
 func (s *SmartContract) RemoveFromPendingOfManager(ctx contractapi.TransactionContextInterface, contractId int, manager string) error {
-	// Get manager's user asset
-	managerAsset, err := s.GetUserAsset(ctx, manager)
-	if err != nil {
+	// Find the contract in the pending contracts of manager
+	if _, err := s.listed(ctx, ListPending, manager, contractId); err != nil {
 		return err
 	}
 
-	// Find the contract in the Pending array of manager
-	var contractIndex int
-	var found bool
-	for i, contract := range managerAsset.Pending {
-		if contract.ContractId == contractId {
-			contractIndex = i
-			found = true
-			break
-		}
-	}
-	if !found {
-		return fmt.Errorf("contract not found in the pending of manager")
-	}
-
-	// Remove the contract from the Pending array of manager
-	managerAsset.Pending = append(managerAsset.Pending[:contractIndex], managerAsset.Pending[contractIndex+1:]...)
-
-	// Update manager's user asset in the world state
-	managerAssetJSON, err := json.Marshal(managerAsset)
-	if err != nil {
-		return err
-	}
-	if err := ctx.GetStub().PutState(manager, managerAssetJSON); err != nil {
+	// Remove the contract from the pending contracts of manager and discard it
+	if err := s.removeFromList(ctx, ListPending, manager, contractId); err != nil {
 		return err
 	}
 
-	return nil
+	return s.deleteContract(ctx, contractId)
 }
 
 func (s *SmartContract) Revoke(ctx contractapi.TransactionContextInterface, contractId int, manager string, contractor string) error {
-	// Get manager's user asset
-	managerAsset, err := s.GetUserAsset(ctx, manager)
+	// Find the contract in the contracts of manager and contractor
+	if _, err := s.listed(ctx, ListContracts, manager, contractId); err != nil {
+		return err
+	}
+	in, err := s.inList(ctx, ListContracts, contractor, contractId)
 	if err != nil {
 		return err
 	}
+	if !in {
+		return fmt.Errorf("contract not found in the contracts of contractor")
+	}
 
-	// Find the contract in the Contracts array of manager
-	var managerContractIndex int
-	var foundManager bool
-	for i, contract := range managerAsset.Contracts {
-		if contract.ContractId == contractId {
-			managerContractIndex = i
-			foundManager = true
-			break
-		}
+	// Remove the contract from the contracts of both parties and discard it
+	if err := s.removeFromList(ctx, ListContracts, manager, contractId); err != nil {
+		return err
 	}
-	if !foundManager {
-		return fmt.Errorf("contract not found in the contracts of manager")
+	if err := s.removeFromList(ctx, ListContracts, contractor, contractId); err != nil {
+		return err
 	}
 
-	// Remove the contract from the Contracts array of manager
-	managerAsset.Contracts = append(managerAsset.Contracts[:managerContractIndex], managerAsset.Contracts[managerContractIndex+1:]...)
+	return s.deleteContract(ctx, contractId)
+}
 
-	// Get contractor's user asset
-	contractorAsset, err := s.GetUserAsset(ctx, contractor)
+// CalculateRedemptionAmount redeems the intervals of a contract completed by the transaction date.
+// asOfDate is normally empty; a date outside the configured tolerance is an admin correction and is logged.
+func (s *SmartContract) CalculateRedemptionAmount(ctx contractapi.TransactionContextInterface, contractId int, manager string, contractor string, asOfDate string) (int, error) {
+	at, err := s.effectiveDate(ctx, "CalculateRedemptionAmount", strconv.Itoa(contractId), asOfDate)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// Find the contract in the Contracts array of contractor
-	var contractorContractIndex int
-	var foundContractor bool
-	for i, contract := range contractorAsset.Contracts {
-		if contract.ContractId == contractId {
-			contractorContractIndex = i
-			foundContractor = true
-			break
-		}
-	}
-	if !foundContractor {
-		return fmt.Errorf("contract not found in the contracts of contractor")
+	// Find the contract in the contracts of manager and contractor
+	contract, err := s.listed(ctx, ListContracts, manager, contractId)
+	if err != nil {
+		return 0, err
 	}
-
-	// Remove the contract from the Contracts array of contractor
-	contractorAsset.Contracts = append(contractorAsset.Contracts[:contractorContractIndex], contractorAsset.Contracts[contractorContractIndex+1:]...)
-
-	// Update manager's user asset in the world state
-	managerAssetJSON, err := json.Marshal(managerAsset)
+	in, err := s.inList(ctx, ListContracts, contractor, contractId)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if err := ctx.GetStub().PutState(manager, managerAssetJSON); err != nil {
-		return err
+	if !in {
+		return 0, fmt.Errorf("contract not found in the contracts of contractor")
 	}
 
-	// Update contractor's user asset in the world state
-	contractorAssetJSON, err := json.Marshal(contractorAsset)
+	settled, err := redemption(*contract, at)
 	if err != nil {
-		return err
-	}
-	if err := ctx.GetStub().PutState(contractor, contractorAssetJSON); err != nil {
-		return err
+		return 0, err
 	}
-
-	return nil
-}
-
-func (s *SmartContract) CalculateRedemptionAmount(ctx contractapi.TransactionContextInterface, contractId int, manager string, contractor string, currentDate string) (int, error) {
-	// Get manager's user asset
-	managerAsset, err := s.GetUserAsset(ctx, manager)
+	work, workAmount, err := s.approvedWork(ctx, *contract)
 	if err != nil {
 		return 0, err
 	}
+	settled.amount += workAmount
+	if err := s.markPaid(ctx, work, at.at.Format(time.RFC3339)); err != nil {
+		return 0, err
+	}
 
-	// Get contractor's user asset
-	contractorAsset, err := s.GetUserAsset(ctx, contractor)
-	if err != nil {
+	// Update the last payment date
+	contract.LastPaymentDate = settled.paidThrough
+
+	// Payments on a disputed contract are held in escrow until the dispute is resolved
+	if err := s.holdInEscrow(ctx, *contract, settled.amount); err != nil {
 		return 0, err
 	}
 
-	// Find the contract in the Contracts array of manager
-	var managerContractIndex int
-	var managerContractFound bool
-	for i, contract := range managerAsset.Contracts {
-		if contract.ContractId == contractId {
-			managerContractIndex = i
-			managerContractFound = true
-			break
+	// A contract at the end of its term moves to Completed, renewing first if it auto-renews
+	if settled.completed {
+		successorId, successorRef, err := successorOf(ctx, *contract)
+		if err != nil {
+			return 0, err
 		}
-	}
-	if !managerContractFound {
-		return 0, fmt.Errorf("contract not found in the contracts of manager")
+		if err := s.completeContract(ctx, contract, at.at.Format(time.RFC3339), successorId, successorRef); err != nil {
+			return 0, err
+		}
+		return settled.amount, nil
 	}
 
-	// Find the contract in the Contracts array of contractor
-	var contractorContractIndex int
-	var contractorContractFound bool
-	for i, contract := range contractorAsset.Contracts {
-		if contract.ContractId == contractId {
-			contractorContractIndex = i
-			contractorContractFound = true
-			break
-		}
+	return settled.amount, s.putContract(ctx, contract)
+}
+
+// settlement is the result of redeeming a contract
+type settlement struct {
+	amount      int
+	paidThrough string
+	completed   bool // the term has ended and this was the final settlement
+}
+
+// redemption calculates the amount owed for the intervals of a contract completed by the as-of date
+// in the contract's time zone, and the last payment date once they have been paid. Once the term has
+// ended it is the final settlement, including any partial interval the pro-rating rule pays for.
+func redemption(contract ContractAsset, at asOf) (settlement, error) {
+	if contract.Interval <= 0 {
+		return settlement{}, fmt.Errorf("contract interval must be positive")
 	}
-	if !contractorContractFound {
-		return 0, fmt.Errorf("contract not found in the contracts of contractor")
+	unit := contract.IntervalUnit
+	if unit == "" {
+		unit = IntervalDays
 	}
 
-	// Get the contract from manager's and contractor's assets
-	managerContract := managerAsset.Contracts[managerContractIndex]
-	contractorContract := contractorAsset.Contracts[contractorContractIndex]
-
-	// Parse current date
-	currentDateParsed, err := time.Parse("02-01-2006", currentDate)
+	loc, err := contractLocation(contract)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse current date: %v", err)
+		return settlement{}, err
 	}
+	currentDate := at.localDate(loc)
+
 	// Parse start date
-	startDateParsed, err := time.Parse("02-01-2006", managerContract.StartDate)
+	startDate, err := parseDate(contract.StartDate)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse start date: %v", err)
+		return settlement{}, fmt.Errorf("failed to parse start date: %v", err)
 	}
 
 	// Calculate end date
-	endDate := startDateParsed.AddDate(0, 0, managerContract.Duration)
+	endDate := startDate.AddDate(0, 0, contract.Duration)
 
 	// Check if current date is beyond end date
-	if currentDateParsed.After(endDate) {
-		currentDateParsed = endDate
+	if currentDate.After(endDate) {
+		currentDate = endDate
 	}
 
 	// Parse last payment date
-	lastPaymentDateParsed, err := time.Parse("02-01-2006", managerContract.LastPaymentDate)
+	lastPaymentDate, err := parseDate(contract.LastPaymentDate)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse last payment date: %v", err)
+		return settlement{}, fmt.Errorf("failed to parse last payment date: %v", err)
 	}
 
-	// Calculate days since last payment
-	daysSinceLastPayment := currentDateParsed.Sub(lastPaymentDateParsed).Hours() / 24
-
-	// Calculate amount
-	interval := float64(managerContract.Interval)
-	ratePerInterval := float64(managerContract.RatePerInterval)
-	amount := int(daysSinceLastPayment/interval) * int(ratePerInterval)
-
-	// Update the last payment date for both manager and contractor
-	// Calculate the number of completed intervals since the last payment
-	completedIntervals := int(daysSinceLastPayment / interval)
-
-	// Calculate the adjustment to the last payment date
-	adjustment := time.Duration(completedIntervals * int(interval))
-
-	// Update the last payment date for both manager and contractor
-	// Calculate the accurate last payment date
-	lastPaymentDateAdjusted := lastPaymentDateParsed.Add(adjustment * 24 * time.Hour).Format("02-01-2006")
-
-	// Update the last payment date for manager
-	managerContract.LastPaymentDate = lastPaymentDateAdjusted
-	managerAsset.Contracts[managerContractIndex] = managerContract
+	// Nothing is owed before the last payment date, which a correction may have moved ahead
+	if currentDate.Before(lastPaymentDate) {
+		return settlement{paidThrough: contract.LastPaymentDate}, nil
+	}
 
-	// Update the last payment date for contractor
-	contractorContract.LastPaymentDate = lastPaymentDateAdjusted
-	contractorAsset.Contracts[contractorContractIndex] = contractorContract
+	// Count the interval boundaries after the last payment up to the current date.
+	// Boundaries are measured from the start date so monthly payments keep their day of the month.
+	completedIntervals := 0
+	paidThrough := lastPaymentDate
+	intervalStart := startDate
+	var intervalEnd time.Time
+	for n := 1; ; n++ {
+		boundary := intervalBoundary(startDate, unit, contract.Interval, n)
+		if boundary.After(currentDate) {
+			intervalEnd = boundary
+			break
+		}
+		intervalStart = boundary
+		if boundary.After(lastPaymentDate) {
+			completedIntervals++
+			paidThrough = boundary
+		}
+	}
 
-	// Update manager's user asset in the world state
-	managerAssetJSON, err := json.Marshal(managerAsset)
-	if err != nil {
-		return 0, err
+	settled := settlement{
+		amount:      completedIntervals * contract.RatePerInterval,
+		paidThrough: formatDate(paidThrough),
 	}
-	if err := ctx.GetStub().PutState(manager, managerAssetJSON); err != nil {
-		return 0, err
+	if completedIntervals == 0 {
+		settled.paidThrough = contract.LastPaymentDate
 	}
 
-	// Update contractor's user asset in the world state
-	contractorAssetJSON, err := json.Marshal(contractorAsset)
-	if err != nil {
-		return 0, err
+	if currentDate.Equal(endDate) {
+		// Pay the unpaid part of the final interval in proportion to the days worked
+		if contract.ProRate == ProRateDaily && paidThrough.Before(endDate) {
+			worked := endDate.Sub(paidThrough).Hours() / 24
+			length := intervalEnd.Sub(intervalStart).Hours() / 24
+			settled.amount += int(float64(contract.RatePerInterval) * worked / length)
+		}
+		settled.paidThrough = formatDate(endDate)
+		settled.completed = true
 	}
-	if err := ctx.GetStub().PutState(contractor, contractorAssetJSON); err != nil {
-		return 0, err
+
+	// Contracts paid per submission owe for approved work, not elapsed time
+	if paidPerSubmission(contract) {
+		settled.amount = 0
 	}
 
-	return amount, nil
+	return settled, nil
 }
@@ -0,0 +1,94 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SplitPayment records that the manager's bank paid one split of a redemption. The banks pay each
+// split in its own transaction, so the records tell which splits a failed fan-out still owes.
+type SplitPayment struct {
+	ContractId  int    `json:"contractId"`
+	Redemption  string `json:"redemption"` // id of the transaction that redeemed the amount
+	Index       int    `json:"index"`      // position of the split in the payment splits of the redemption
+	Username    string `json:"username"`
+	Amount      int    `json:"amount"`
+	PaymentTxId string `json:"paymentTxId"` // id of the bank transaction that paid the split
+	RecordedAt  string `json:"recordedAt"`
+}
+
+// RecordSplitPayment records that a split of a redemption on a manager's contract was paid.
+// A split can be recorded only once, so a retried fan-out cannot record it as paid twice.
+func (s *SmartContract) RecordSplitPayment(ctx contractapi.TransactionContextInterface, contractId int, manager string, redemption string, index int, username string, amount int, paymentTxId string) error {
+	if redemption == "" || paymentTxId == "" {
+		return fmt.Errorf("redemption and payment transaction ids are required")
+	}
+	if index < 0 {
+		return fmt.Errorf("split index cannot be negative")
+	}
+	if _, err := s.managedContract(ctx, contractId, manager); err != nil {
+		return err
+	}
+
+	paymentKey, err := ctx.GetStub().CreateCompositeKey("splitpayment", []string{strconv.Itoa(contractId), redemption, strconv.Itoa(index)})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(paymentKey)
+	if err != nil {
+		return fmt.Errorf("failed to read split payment from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("split %d of redemption %s is already paid", index, redemption)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	paymentJSON, err := json.Marshal(SplitPayment{
+		ContractId:  contractId,
+		Redemption:  redemption,
+		Index:       index,
+		Username:    username,
+		Amount:      amount,
+		PaymentTxId: paymentTxId,
+		RecordedAt:  now.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(paymentKey, paymentJSON)
+}
+
+// GetSplitPayments lists the splits of a redemption on a contract that have been paid, in split order
+func (s *SmartContract) GetSplitPayments(ctx contractapi.TransactionContextInterface, contractId int, redemption string) ([]SplitPayment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("splitpayment", []string{strconv.Itoa(contractId), redemption})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	payments := []SplitPayment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var payment SplitPayment
+		if err := json.Unmarshal(queryResponse.Value, &payment); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].Index < payments[j].Index })
+
+	return payments, nil
+}
@@ -0,0 +1,295 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Contract lists. Each contract is stored once under its id, and each list a user holds it in is an
+// index entry keyed by list, username and contract id. Operations on different contracts of one user
+// therefore touch different keys, and the user asset itself only changes with the user's profile.
+const (
+	ListRequests  = "requests"
+	ListPending   = "pending"
+	ListContracts = "contracts"
+	ListCompleted = "completed"
+)
+
+// indexEntry is the value of a list entry; an empty value would delete the key
+var indexEntry = []byte{0x00}
+
+// getContract retrieves a contract by its id
+func (s *SmartContract) getContract(ctx contractapi.TransactionContextInterface, contractId int) (*ContractAsset, error) {
+	contractKey, err := ctx.GetStub().CreateCompositeKey("contract", []string{strconv.Itoa(contractId)})
+	if err != nil {
+		return nil, err
+	}
+	contractJSON, err := ctx.GetStub().GetState(contractKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contract from world state: %v", err)
+	}
+	if contractJSON == nil {
+		return nil, fmt.Errorf("contract %d does not exist", contractId)
+	}
+
+	var contract ContractAsset
+	if err := json.Unmarshal(contractJSON, &contract); err != nil {
+		return nil, err
+	}
+
+	return &contract, nil
+}
+
+func (s *SmartContract) putContract(ctx contractapi.TransactionContextInterface, contract *ContractAsset) error {
+	contractKey, err := ctx.GetStub().CreateCompositeKey("contract", []string{strconv.Itoa(contract.ContractId)})
+	if err != nil {
+		return err
+	}
+	contractJSON, err := json.Marshal(contract)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(contractKey, contractJSON)
+}
+
+func (s *SmartContract) deleteContract(ctx contractapi.TransactionContextInterface, contractId int) error {
+	contractKey, err := ctx.GetStub().CreateCompositeKey("contract", []string{strconv.Itoa(contractId)})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(contractKey)
+}
+
+// listed returns a contract if it is in one of a user's lists
+func (s *SmartContract) listed(ctx contractapi.TransactionContextInterface, list string, username string, contractId int) (*ContractAsset, error) {
+	in, err := s.inList(ctx, list, username, contractId)
+	if err != nil {
+		return nil, err
+	}
+	if !in {
+		return nil, fmt.Errorf("contract not found in the %s of %s", list, username)
+	}
+
+	return s.getContract(ctx, contractId)
+}
+
+func (s *SmartContract) inList(ctx contractapi.TransactionContextInterface, list string, username string, contractId int) (bool, error) {
+	entryKey, err := ctx.GetStub().CreateCompositeKey("usercontract", []string{list, username, strconv.Itoa(contractId)})
+	if err != nil {
+		return false, err
+	}
+	entry, err := ctx.GetStub().GetState(entryKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read contract list from world state: %v", err)
+	}
+
+	return entry != nil, nil
+}
+
+func (s *SmartContract) addToList(ctx contractapi.TransactionContextInterface, list string, username string, contractId int) error {
+	entryKey, err := ctx.GetStub().CreateCompositeKey("usercontract", []string{list, username, strconv.Itoa(contractId)})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(entryKey, indexEntry)
+}
+
+func (s *SmartContract) removeFromList(ctx contractapi.TransactionContextInterface, list string, username string, contractId int) error {
+	entryKey, err := ctx.GetStub().CreateCompositeKey("usercontract", []string{list, username, strconv.Itoa(contractId)})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(entryKey)
+}
+
+// listContracts retrieves the contracts in one of a user's lists. It is a range query, so transactions
+// that write should look contracts up with listed instead to keep their read sets small.
+func (s *SmartContract) listContracts(ctx contractapi.TransactionContextInterface, list string, username string) ([]ContractAsset, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("usercontract", []string{list, username})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	contracts := []ContractAsset{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		contractId, err := strconv.Atoi(attributes[2])
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert contract id to int: %v", err)
+		}
+
+		contract, err := s.getContract(ctx, contractId)
+		if err != nil {
+			return nil, err
+		}
+		contracts = append(contracts, *contract)
+	}
+
+	return contracts, nil
+}
+
+// userProfile retrieves a user asset without its contract lists, for transactions that only need the
+// user's details or update them. Users still holding contracts in their asset have to be migrated first.
+func (s *SmartContract) userProfile(ctx contractapi.TransactionContextInterface, username string) (*UserAsset, error) {
+	userAsset, err := s.readUserAsset(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if holdsContracts(userAsset) {
+		return nil, fmt.Errorf("user asset %s still holds its contracts, run MigrateUserAsset first", username)
+	}
+
+	return userAsset, nil
+}
+
+func (s *SmartContract) putUserProfile(ctx contractapi.TransactionContextInterface, userAsset *UserAsset) error {
+	userAsset.Contracts = []ContractAsset{}
+	userAsset.Requests = []ContractAsset{}
+	userAsset.Pending = []ContractAsset{}
+	userAsset.Completed = []ContractAsset{}
+
+	userAssetJSON, err := json.Marshal(userAsset)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(userAsset.Username, userAssetJSON)
+}
+
+func (s *SmartContract) readUserAsset(ctx contractapi.TransactionContextInterface, username string) (*UserAsset, error) {
+	userAssetJSON, err := ctx.GetStub().GetState(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user asset from world state: %v", err)
+	}
+	if userAssetJSON == nil {
+		return nil, fmt.Errorf("user asset with username %s does not exist", username)
+	}
+
+	var userAsset UserAsset
+	if err := json.Unmarshal(userAssetJSON, &userAsset); err != nil {
+		return nil, err
+	}
+
+	return &userAsset, nil
+}
+
+// MigrateUserAsset moves the contracts a user asset holds from before contracts had their own keys
+// into the contract store and the user's contract lists, and marks the user as migrated. Both parties
+// of a contract hold a copy. The first party migrated stores it; migrating the other only adds their list
+// entries, so it does not overwrite the contract with their stale copy. A contract deleted after the other
+// party was migrated is not restored and gets no list entries.
+func (s *SmartContract) MigrateUserAsset(ctx contractapi.TransactionContextInterface, username string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	userAsset, err := s.readUserAsset(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	// stored tells for each contract seen whether it is in the contract store after this call
+	stored := map[int]bool{}
+	for _, list := range []struct {
+		name      string
+		contracts []ContractAsset
+	}{
+		{ListRequests, userAsset.Requests},
+		{ListPending, userAsset.Pending},
+		{ListContracts, userAsset.Contracts},
+		{ListCompleted, userAsset.Completed},
+	} {
+		for i := range list.contracts {
+			contract := &list.contracts[i]
+			exists, seen := stored[contract.ContractId]
+			if !seen {
+				if exists, err = s.migrateContract(ctx, username, contract); err != nil {
+					return err
+				}
+				stored[contract.ContractId] = exists
+			}
+			if !exists {
+				continue
+			}
+			if err := s.addToList(ctx, list.name, username, contract.ContractId); err != nil {
+				return err
+			}
+		}
+	}
+
+	markerKey, err := ctx.GetStub().CreateCompositeKey("migrated", []string{username})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(markerKey, indexEntry); err != nil {
+		return err
+	}
+
+	return s.putUserProfile(ctx, userAsset)
+}
+
+// migrateContract stores a user's copy of a contract unless the contract store already has it or the
+// other party has been migrated and no longer lists it, and reports whether the contract is in the store.
+// A party without contracts of its own is not taken as migrated: a request is only held by the contractor
+// and a pending contract only by the manager.
+func (s *SmartContract) migrateContract(ctx contractapi.TransactionContextInterface, username string, contract *ContractAsset) (bool, error) {
+	contractKey, err := ctx.GetStub().CreateCompositeKey("contract", []string{strconv.Itoa(contract.ContractId)})
+	if err != nil {
+		return false, err
+	}
+	existing, err := ctx.GetStub().GetState(contractKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read contract from world state: %v", err)
+	}
+	if existing != nil {
+		return true, nil
+	}
+
+	other := contract.Manager
+	if other == username {
+		other = contract.Contractor
+	}
+	markerKey, err := ctx.GetStub().CreateCompositeKey("migrated", []string{other})
+	if err != nil {
+		return false, err
+	}
+	migrated, err := ctx.GetStub().GetState(markerKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read migration marker from world state: %v", err)
+	}
+	if migrated != nil {
+		for _, list := range []string{ListRequests, ListPending, ListContracts, ListCompleted} {
+			in, err := s.inList(ctx, list, other, contract.ContractId)
+			if err != nil {
+				return false, err
+			}
+			if in {
+				return true, s.putContract(ctx, contract)
+			}
+		}
+		// The other party was migrated and no longer lists the contract, so it has been deleted since
+		return false, nil
+	}
+
+	return true, s.putContract(ctx, contract)
+}
+
+// holdsContracts reports whether a user asset still holds its contracts from before contracts had their own keys
+func holdsContracts(userAsset *UserAsset) bool {
+	return len(userAsset.Contracts)+len(userAsset.Requests)+len(userAsset.Pending)+len(userAsset.Completed) > 0
+}
@@ -0,0 +1,265 @@
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Pay bases. Time-based contracts are paid per elapsed interval; hourly and milestone
+// contracts are paid the rate for each approved hour or deliverable.
+const (
+	PayBasisTime      = "time"
+	PayBasisHourly    = "hourly"
+	PayBasisMilestone = "milestone"
+)
+
+// Submission kinds
+const (
+	SubmissionTimesheet   = "timesheet"
+	SubmissionDeliverable = "deliverable"
+)
+
+// Submission statuses
+const (
+	SubmissionSubmitted = "Submitted"
+	SubmissionApproved  = "Approved"
+	SubmissionRejected  = "Rejected"
+	SubmissionPaid      = "Paid"
+)
+
+// Submission is a timesheet or deliverable a contractor submits for payment on an hourly or milestone contract
+type Submission struct {
+	SubmissionId string  `json:"submissionId"`
+	ContractId   int     `json:"contractId"`
+	Contractor   string  `json:"contractor"`
+	Kind         string  `json:"kind"`
+	Units        float64 `json:"units"`        // hours on a timesheet, one per deliverable
+	PeriodStart  string  `json:"periodStart"`  // first day a timesheet covers
+	PeriodEnd    string  `json:"periodEnd"`    // last day a timesheet covers
+	DocumentHash string  `json:"documentHash"` // hex SHA-256 of a deliverable
+	Description  string  `json:"description"`
+	Status       string  `json:"status"`
+	Reason       string  `json:"reason"` // why the manager rejected it
+	SubmittedAt  string  `json:"submittedAt"`
+	DecidedAt    string  `json:"decidedAt"`
+	PaidAt       string  `json:"paidAt"`
+}
+
+// SubmitTimesheet records hours worked between two dates on an hourly contract for the manager to approve
+func (s *SmartContract) SubmitTimesheet(ctx contractapi.TransactionContextInterface, contractId int, contractor string, hours float64, periodStart string, periodEnd string, description string) (*Submission, error) {
+	contract, err := s.listed(ctx, ListContracts, contractor, contractId)
+	if err != nil {
+		return nil, err
+	}
+	if contract.Contractor != contractor {
+		return nil, fmt.Errorf("only the contractor can submit a timesheet")
+	}
+	if contract.PayBasis != PayBasisHourly {
+		return nil, fmt.Errorf("contract %d is not paid by the hour", contractId)
+	}
+	if hours <= 0 {
+		return nil, fmt.Errorf("hours must be positive")
+	}
+
+	from, err := parseDate(periodStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse period start: %v", err)
+	}
+	to, err := parseDate(periodEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse period end: %v", err)
+	}
+	start, err := parseDate(contract.StartDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse start date: %v", err)
+	}
+	if to.Before(from) || from.Before(start) || to.After(start.AddDate(0, 0, contract.Duration)) {
+		return nil, fmt.Errorf("timesheet period must lie within the term of the contract")
+	}
+
+	return s.putSubmission(ctx, "WorkSubmitted", &Submission{
+		ContractId:  contractId,
+		Contractor:  contractor,
+		Kind:        SubmissionTimesheet,
+		Units:       hours,
+		PeriodStart: formatDate(from),
+		PeriodEnd:   formatDate(to),
+		Description: description,
+	})
+}
+
+// SubmitDeliverable records the hex SHA-256 hash of a deliverable on a milestone contract for the manager to approve
+func (s *SmartContract) SubmitDeliverable(ctx contractapi.TransactionContextInterface, contractId int, contractor string, documentHash string, description string) (*Submission, error) {
+	contract, err := s.listed(ctx, ListContracts, contractor, contractId)
+	if err != nil {
+		return nil, err
+	}
+	if contract.Contractor != contractor {
+		return nil, fmt.Errorf("only the contractor can submit a deliverable")
+	}
+	if contract.PayBasis != PayBasisMilestone {
+		return nil, fmt.Errorf("contract %d is not paid by milestone", contractId)
+	}
+	if hash, err := hex.DecodeString(documentHash); err != nil || len(hash) != 32 {
+		return nil, fmt.Errorf("document hash must be a hex encoded SHA-256 hash")
+	}
+
+	return s.putSubmission(ctx, "WorkSubmitted", &Submission{
+		ContractId:   contractId,
+		Contractor:   contractor,
+		Kind:         SubmissionDeliverable,
+		Units:        1,
+		DocumentHash: documentHash,
+		Description:  description,
+	})
+}
+
+// ApproveSubmission accepts a timesheet or deliverable, making it payable at the next redemption
+func (s *SmartContract) ApproveSubmission(ctx contractapi.TransactionContextInterface, contractId int, submissionId string, manager string) error {
+	return s.decideSubmission(ctx, contractId, submissionId, manager, SubmissionApproved, "")
+}
+
+// RejectSubmission declines a timesheet or deliverable with a reason for the contractor
+func (s *SmartContract) RejectSubmission(ctx contractapi.TransactionContextInterface, contractId int, submissionId string, manager string, reason string) error {
+	return s.decideSubmission(ctx, contractId, submissionId, manager, SubmissionRejected, reason)
+}
+
+// GetSubmissions lists the timesheets and deliverables submitted on a contract
+func (s *SmartContract) GetSubmissions(ctx contractapi.TransactionContextInterface, contractId int) ([]Submission, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("submission", []string{strconv.Itoa(contractId)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	submissions := []Submission{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var submission Submission
+		if err := json.Unmarshal(queryResponse.Value, &submission); err != nil {
+			return nil, err
+		}
+		submissions = append(submissions, submission)
+	}
+
+	return submissions, nil
+}
+
+func (s *SmartContract) decideSubmission(ctx contractapi.TransactionContextInterface, contractId int, submissionId string, manager string, decision string, reason string) error {
+	contract, err := s.listed(ctx, ListContracts, manager, contractId)
+	if err != nil {
+		return err
+	}
+	if contract.Manager != manager {
+		return fmt.Errorf("only the manager can approve or reject submissions")
+	}
+
+	submissionKey, err := ctx.GetStub().CreateCompositeKey("submission", []string{strconv.Itoa(contractId), submissionId})
+	if err != nil {
+		return err
+	}
+	submissionJSON, err := ctx.GetStub().GetState(submissionKey)
+	if err != nil {
+		return fmt.Errorf("failed to read submission from world state: %v", err)
+	}
+	if submissionJSON == nil {
+		return fmt.Errorf("submission %s does not exist on contract %d", submissionId, contractId)
+	}
+
+	var submission Submission
+	if err := json.Unmarshal(submissionJSON, &submission); err != nil {
+		return err
+	}
+	if submission.Status != SubmissionSubmitted {
+		return fmt.Errorf("submission %s is already %s", submissionId, submission.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	submission.Status = decision
+	submission.Reason = reason
+	submission.DecidedAt = now.Format(time.RFC3339)
+
+	_, err = s.putSubmission(ctx, "Submission"+decision, &submission)
+	return err
+}
+
+// putSubmission stores a submission, stamping a new one with its id and time, and emits it as an event
+func (s *SmartContract) putSubmission(ctx contractapi.TransactionContextInterface, event string, submission *Submission) (*Submission, error) {
+	if submission.SubmissionId == "" {
+		now, err := txTime(ctx)
+		if err != nil {
+			return nil, err
+		}
+		submission.SubmissionId = ctx.GetStub().GetTxID()
+		submission.Status = SubmissionSubmitted
+		submission.SubmittedAt = now.Format(time.RFC3339)
+	}
+
+	submissionKey, err := ctx.GetStub().CreateCompositeKey("submission", []string{strconv.Itoa(submission.ContractId), submission.SubmissionId})
+	if err != nil {
+		return nil, err
+	}
+	submissionJSON, err := json.Marshal(submission)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(submissionKey, submissionJSON); err != nil {
+		return nil, err
+	}
+
+	return submission, ctx.GetStub().SetEvent(event, submissionJSON)
+}
+
+// approvedWork returns the approved, unpaid submissions on a contract paid per submission
+// and the amount they are worth at the contract's rate
+func (s *SmartContract) approvedWork(ctx contractapi.TransactionContextInterface, contract ContractAsset) ([]Submission, int, error) {
+	if !paidPerSubmission(contract) {
+		return nil, 0, nil
+	}
+
+	submissions, err := s.GetSubmissions(ctx, contract.ContractId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	approved := []Submission{}
+	units := 0.0
+	for _, submission := range submissions {
+		if submission.Status == SubmissionApproved {
+			approved = append(approved, submission)
+			units += submission.Units
+		}
+	}
+
+	return approved, int(units * float64(contract.RatePerInterval)), nil
+}
+
+// markPaid records approved submissions as paid by a redemption
+func (s *SmartContract) markPaid(ctx contractapi.TransactionContextInterface, submissions []Submission, paidAt string) error {
+	for i := range submissions {
+		submissions[i].Status = SubmissionPaid
+		submissions[i].PaidAt = paidAt
+		if _, err := s.putSubmission(ctx, "SubmissionPaid", &submissions[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// paidPerSubmission reports whether a contract is paid for approved work rather than elapsed intervals
+func paidPerSubmission(contract ContractAsset) bool {
+	return contract.PayBasis == PayBasisHourly || contract.PayBasis == PayBasisMilestone
+}
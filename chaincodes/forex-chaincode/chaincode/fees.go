@@ -0,0 +1,220 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FeeTier sets the fee for amounts up to UpTo. An UpTo of 0 covers every larger amount.
+type FeeTier struct {
+	UpTo    int     `json:"upTo"`
+	Percent float64 `json:"percent"`
+	Flat    int     `json:"flat"`
+}
+
+// FeeSchedule is the fee charged on conversions in a currency corridor for a bank from EffectiveFrom onwards.
+// A corridor or bank of "*" applies when no more specific schedule exists.
+// The fee is the percentage plus the flat amount, taken from the first tier covering the amount when tiers are set,
+// and clamped to MinFee and MaxFee. A MaxFee of 0 means no cap. Amounts are in the currency the fee is charged in.
+type FeeSchedule struct {
+	Corridor      string    `json:"corridor"`
+	Bank          string    `json:"bank"`
+	EffectiveFrom string    `json:"effectiveFrom"`
+	Percent       float64   `json:"percent"`
+	Flat          int       `json:"flat"`
+	Tiers         []FeeTier `json:"tiers,omitempty" metadata:",optional"`
+	MinFee        int       `json:"minFee"`
+	MaxFee        int       `json:"maxFee"`
+	SetBy         string    `json:"setBy"`
+}
+
+// defaultFeeSchedule applies when no schedule has been set on the ledger
+var defaultFeeSchedule = FeeSchedule{
+	Corridor:      "*",
+	Bank:          "*",
+	EffectiveFrom: time.Time{}.Format(time.RFC3339),
+	Percent:       forexFeePercent,
+}
+
+// feeOf returns the fee the schedule charges on an amount
+func (f *FeeSchedule) feeOf(amount int) int {
+	percent, flat := f.Percent, f.Flat
+	for _, tier := range f.Tiers {
+		if tier.UpTo == 0 || amount <= tier.UpTo {
+			percent, flat = tier.Percent, tier.Flat
+			break
+		}
+	}
+
+	fee := int(float64(amount)*percent/100) + flat
+	if fee < f.MinFee {
+		fee = f.MinFee
+	}
+	if f.MaxFee > 0 && fee > f.MaxFee {
+		fee = f.MaxFee
+	}
+	if fee > amount {
+		fee = amount
+	}
+	if fee < 0 {
+		fee = 0
+	}
+
+	return fee
+}
+
+// SetFeeSchedule adds a fee schedule for a corridor such as USD-INR and a bank, effective from an RFC 3339 time.
+// Schedules cannot take effect in the past, so the fees of earlier conversions can always be explained.
+// Tiers are given as a JSON array and may be empty.
+func (s *SmartContract) SetFeeSchedule(ctx contractapi.TransactionContextInterface, corridor string, bank string, effectiveFrom string, percent float64, flat int, tiersJSON string, minFee int, maxFee int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	effective, err := time.Parse(time.RFC3339, effectiveFrom)
+	if err != nil {
+		return fmt.Errorf("effective from must be an RFC 3339 time: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if effective.Before(now) {
+		return fmt.Errorf("fee schedules cannot take effect in the past")
+	}
+
+	var tiers []FeeTier
+	if tiersJSON != "" {
+		if err := json.Unmarshal([]byte(tiersJSON), &tiers); err != nil {
+			return fmt.Errorf("failed to parse fee tiers: %v", err)
+		}
+	}
+	for _, rate := range append([]float64{percent}, tierPercents(tiers)...) {
+		if rate < 0 || rate > 100 {
+			return fmt.Errorf("fee percentages must be between 0 and 100")
+		}
+	}
+	if flat < 0 || minFee < 0 || maxFee < 0 {
+		return fmt.Errorf("fee amounts cannot be negative")
+	}
+	if maxFee > 0 && minFee > maxFee {
+		return fmt.Errorf("minimum fee cannot exceed maximum fee")
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	schedule := FeeSchedule{
+		Corridor:      strings.ToUpper(corridor),
+		Bank:          strings.ToLower(bank),
+		EffectiveFrom: effective.UTC().Format(time.RFC3339),
+		Percent:       percent,
+		Flat:          flat,
+		Tiers:         tiers,
+		MinFee:        minFee,
+		MaxFee:        maxFee,
+		SetBy:         clientID,
+	}
+
+	scheduleKey, err := ctx.GetStub().CreateCompositeKey("feeschedule", []string{schedule.Corridor, schedule.Bank, schedule.EffectiveFrom})
+	if err != nil {
+		return err
+	}
+	scheduleJSON, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(scheduleKey, scheduleJSON)
+}
+
+func tierPercents(tiers []FeeTier) []float64 {
+	percents := make([]float64, len(tiers))
+	for i, tier := range tiers {
+		percents[i] = tier.Percent
+	}
+	return percents
+}
+
+// GetFeeSchedules returns every fee schedule, including those superseded or not yet in effect
+func (s *SmartContract) GetFeeSchedules(ctx contractapi.TransactionContextInterface) ([]FeeSchedule, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("feeschedule", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	schedules := []FeeSchedule{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var schedule FeeSchedule
+		if err := json.Unmarshal(queryResponse.Value, &schedule); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+// GetEffectiveFeeSchedule returns the fee schedule that applied to a corridor and bank at an RFC 3339 time
+func (s *SmartContract) GetEffectiveFeeSchedule(ctx contractapi.TransactionContextInterface, corridor string, bank string, at string) (*FeeSchedule, error) {
+	atTime, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return nil, fmt.Errorf("time must be in RFC 3339 format: %v", err)
+	}
+
+	return s.effectiveFeeSchedule(ctx, corridor, bank, atTime)
+}
+
+// effectiveFeeSchedule finds the latest schedule in effect at a time, preferring the exact bank and corridor
+// over the "*" wildcards and falling back to the default schedule
+func (s *SmartContract) effectiveFeeSchedule(ctx contractapi.TransactionContextInterface, corridor string, bank string, at time.Time) (*FeeSchedule, error) {
+	cutoff := at.UTC().Format(time.RFC3339)
+
+	for _, b := range []string{strings.ToLower(bank), "*"} {
+		for _, c := range []string{strings.ToUpper(corridor), "*"} {
+			resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("feeschedule", []string{c, b})
+			if err != nil {
+				return nil, err
+			}
+
+			var latest *FeeSchedule
+			for resultsIterator.HasNext() {
+				queryResponse, err := resultsIterator.Next()
+				if err != nil {
+					resultsIterator.Close()
+					return nil, err
+				}
+
+				var schedule FeeSchedule
+				if err := json.Unmarshal(queryResponse.Value, &schedule); err != nil {
+					resultsIterator.Close()
+					return nil, err
+				}
+				// Keys are ordered by effective time, so the last one not after the cutoff wins
+				if schedule.EffectiveFrom <= cutoff {
+					latest = &schedule
+				}
+			}
+			resultsIterator.Close()
+
+			if latest != nil {
+				return latest, nil
+			}
+		}
+	}
+
+	schedule := defaultFeeSchedule
+	return &schedule, nil
+}
@@ -0,0 +1,189 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// quoteValidity is how long a quote can be executed after it is issued
+const quoteValidity = 15 * time.Minute
+
+// Quote locks a conversion rate and fee for a fixed amount and bank until it expires.
+// Quotes are not signed: they are only ever read back from the forex ledger, where the endorsed
+// RequestQuote transaction stored them, so the ledger is the authority on their terms.
+// A quote can only be executed by the organization whose client requested it.
+type Quote struct {
+	QuoteId         string  `json:"quoteId"`
+	CurrencyFrom    string  `json:"currencyFrom"`
+	CurrencyTo      string  `json:"currencyTo"`
+	Amount          int     `json:"amount"`
+	Bank            string  `json:"bank"`
+	Rate            float64 `json:"rate"`
+	FeeSchedule     string  `json:"feeSchedule"`
+	FeePercent      float64 `json:"feePercent"`
+	Fees            int     `json:"fees"` // in currencyTo
+	ConvertedAmount int     `json:"convertedAmount"`
+	IssuedAt        string  `json:"issuedAt"`
+	ExpiresAt       string  `json:"expiresAt"`
+	MSPID           string  `json:"mspId"`
+	Used            bool    `json:"used"`
+	UsedByTx        string  `json:"usedByTx"`
+}
+
+// RequestQuote issues a quote converting amount from currencyFrom to currencyTo at the current rate
+// and the fee schedule of a bank. Only payments submitted by the caller's organization can execute the quote.
+func (s *SmartContract) RequestQuote(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string) (*Quote, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	conversion, err := s.convert(ctx, currencyFrom, currencyTo, amount, bank)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	quote := Quote{
+		QuoteId:         ctx.GetStub().GetTxID(),
+		CurrencyFrom:    currencyFrom,
+		CurrencyTo:      currencyTo,
+		Amount:          amount,
+		Bank:            bank,
+		Rate:            conversion.Rate,
+		FeeSchedule:     conversion.FeeSchedule,
+		FeePercent:      conversion.FeePercent,
+		Fees:            conversion.Fees,
+		ConvertedAmount: conversion.ConvertedAmount,
+		IssuedAt:        now.Format(time.RFC3339),
+		ExpiresAt:       now.Add(quoteValidity).Format(time.RFC3339),
+		MSPID:           mspID,
+	}
+
+	if err := s.putQuote(ctx, &quote); err != nil {
+		return nil, err
+	}
+
+	return &quote, nil
+}
+
+// GetQuote retrieves a quote by its id
+func (s *SmartContract) GetQuote(ctx contractapi.TransactionContextInterface, quoteId string) (*Quote, error) {
+	quoteKey, err := ctx.GetStub().CreateCompositeKey("quote", []string{quoteId})
+	if err != nil {
+		return nil, err
+	}
+	quoteJSON, err := ctx.GetStub().GetState(quoteKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quote from world state: %v", err)
+	}
+	if quoteJSON == nil {
+		return nil, fmt.Errorf("quote %s does not exist", quoteId)
+	}
+
+	var quote Quote
+	if err := json.Unmarshal(quoteJSON, &quote); err != nil {
+		return nil, err
+	}
+
+	return &quote, nil
+}
+
+func (s *SmartContract) putQuote(ctx contractapi.TransactionContextInterface, quote *Quote) error {
+	quoteKey, err := ctx.GetStub().CreateCompositeKey("quote", []string{quote.QuoteId})
+	if err != nil {
+		return err
+	}
+	quoteJSON, err := json.Marshal(quote)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(quoteKey, quoteJSON)
+}
+
+// ExecuteQuote converts at the locked rate of a quote and marks it used.
+// It rejects quotes that are expired, already used, requested by another organization, or whose terms do not match the payment.
+func (s *SmartContract) ExecuteQuote(ctx contractapi.TransactionContextInterface, quoteId string, currencyFrom string, currencyTo string, amount int) (int, error) {
+	quote, err := s.GetQuote(ctx, quoteId)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkQuote(ctx, quote, currencyFrom, currencyTo, amount); err != nil {
+		return 0, err
+	}
+
+	quote.Used = true
+	quote.UsedByTx = ctx.GetStub().GetTxID()
+
+	if err := s.putQuote(ctx, quote); err != nil {
+		return 0, err
+	}
+
+	return quote.ConvertedAmount, nil
+}
+
+// PreviewQuote returns the breakdown of a conversion at the locked rate of a quote without using the quote.
+// It fails whenever ExecuteQuote would.
+func (s *SmartContract) PreviewQuote(ctx contractapi.TransactionContextInterface, quoteId string, currencyFrom string, currencyTo string, amount int) (*Conversion, error) {
+	quote, err := s.GetQuote(ctx, quoteId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkQuote(ctx, quote, currencyFrom, currencyTo, amount); err != nil {
+		return nil, err
+	}
+
+	return &Conversion{
+		CurrencyFrom:    quote.CurrencyFrom,
+		CurrencyTo:      quote.CurrencyTo,
+		Amount:          quote.Amount,
+		Bank:            quote.Bank,
+		Rate:            quote.Rate,
+		GrossAmount:     quote.ConvertedAmount + quote.Fees,
+		FeeSchedule:     quote.FeeSchedule,
+		FeePercent:      quote.FeePercent,
+		Fees:            quote.Fees,
+		ConvertedAmount: quote.ConvertedAmount,
+	}, nil
+}
+
+// checkQuote returns an error unless the caller can execute the quote for a payment
+func checkQuote(ctx contractapi.TransactionContextInterface, quote *Quote, currencyFrom string, currencyTo string, amount int) error {
+	if quote.Used {
+		return fmt.Errorf("quote %s was already used by transaction %s", quote.QuoteId, quote.UsedByTx)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != quote.MSPID {
+		return fmt.Errorf("quote %s was requested by %s, not %s", quote.QuoteId, quote.MSPID, mspID)
+	}
+	if quote.CurrencyFrom != currencyFrom || quote.CurrencyTo != currencyTo || quote.Amount != amount {
+		return fmt.Errorf("quote %s is for %d %s to %s", quote.QuoteId, quote.Amount, quote.CurrencyFrom, quote.CurrencyTo)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	expiresAt, err := time.Parse(time.RFC3339, quote.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to parse quote expiry: %v", err)
+	}
+	if now.After(expiresAt) {
+		return fmt.Errorf("quote %s expired at %s", quote.QuoteId, quote.ExpiresAt)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package chaincode
 
 import (
     "fmt"
+    "time"
 
     "github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -14,21 +15,140 @@ type SmartContract struct {
 
 // InitLedger initializes the ledger with sample assets
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-    // Initialize any necessary data here
-    
+    // The first identity to initialize the ledger becomes the forex admin
+    adminKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"admin"})
+    if err != nil {
+        return err
+    }
+    adminBytes, err := ctx.GetStub().GetState(adminKey)
+    if err != nil {
+        return fmt.Errorf("failed to read admin from world state: %v", err)
+    }
+    if adminBytes != nil {
+        return nil
+    }
+
+    clientID, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return fmt.Errorf("failed to get client identity: %v", err)
+    }
+
+    return ctx.GetStub().PutState(adminKey, []byte(clientID))
+}
+
+// requireAdmin returns an error unless the caller is the forex admin
+func (s *SmartContract) requireAdmin(ctx contractapi.TransactionContextInterface) error {
+    adminKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"admin"})
+    if err != nil {
+        return err
+    }
+    adminBytes, err := ctx.GetStub().GetState(adminKey)
+    if err != nil {
+        return fmt.Errorf("failed to read admin from world state: %v", err)
+    }
+    if adminBytes == nil {
+        return fmt.Errorf("forex admin is not set, run InitLedger first")
+    }
+
+    clientID, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return fmt.Errorf("failed to get client identity: %v", err)
+    }
+    if clientID != string(adminBytes) {
+        return fmt.Errorf("caller is not the forex admin")
+    }
+
     return nil
 }
 
-func (s *SmartContract) Forex(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int) (int, error) {
-    
-    forexFees := 0.01 // 1%
-    feesMultiplier := 1.0 - forexFees
+// txTime returns the transaction timestamp, which is identical on every endorsing peer
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+    timestamp, err := ctx.GetStub().GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+    }
+
+    return timestamp.AsTime().UTC(), nil
+}
 
+// forexFeePercent is the fee charged on conversions when no fee schedule has been set
+const forexFeePercent = 1.0
+
+// spotRate returns how many units of currencyTo one unit of currencyFrom buys
+func spotRate(currencyFrom string, currencyTo string) (float64, error) {
     if currencyFrom == "USD" && currencyTo == "INR" {
-        return int(float64(amount) * 83 * feesMultiplier), nil
+        return 83, nil
     } else if currencyFrom == "INR" && currencyTo == "USD" {
-        return int(float64(amount)* feesMultiplier / 83), nil
+        return 1.0 / 83, nil
     }
-    
+
     return 0, fmt.Errorf("invalid currency pair")
-}
\ No newline at end of file
+}
+
+// Conversion itemizes the conversion of an amount at the current rate
+type Conversion struct {
+    CurrencyFrom    string  `json:"currencyFrom"`
+    CurrencyTo      string  `json:"currencyTo"`
+    Amount          int     `json:"amount"`
+    Bank            string  `json:"bank"`
+    Rate            float64 `json:"rate"`
+    GrossAmount     int     `json:"grossAmount"` // in currencyTo, before the fee
+    FeeSchedule     string  `json:"feeSchedule"`
+    FeePercent      float64 `json:"feePercent"` // effective percentage of the gross amount
+    Fees            int     `json:"fees"`
+    ConvertedAmount int     `json:"convertedAmount"`
+}
+
+// convert converts an amount for a bank at the current rate, charging the fee schedule in effect
+func (s *SmartContract) convert(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string) (*Conversion, error) {
+
+    rate, err := spotRate(currencyFrom, currencyTo)
+    if err != nil {
+        return nil, err
+    }
+    now, err := txTime(ctx)
+    if err != nil {
+        return nil, err
+    }
+    schedule, err := s.effectiveFeeSchedule(ctx, currencyFrom+"-"+currencyTo, bank, now)
+    if err != nil {
+        return nil, err
+    }
+
+    gross := int(float64(amount) * rate)
+    fees := schedule.feeOf(gross)
+
+    feePercent := 0.0
+    if gross > 0 {
+        feePercent = float64(fees) * 100 / float64(gross)
+    }
+
+    return &Conversion{
+        CurrencyFrom:    currencyFrom,
+        CurrencyTo:      currencyTo,
+        Amount:          amount,
+        Bank:            bank,
+        Rate:            rate,
+        GrossAmount:     gross,
+        FeeSchedule:     schedule.Corridor + "/" + schedule.Bank + "/" + schedule.EffectiveFrom,
+        FeePercent:      feePercent,
+        Fees:            fees,
+        ConvertedAmount: gross - fees,
+    }, nil
+}
+
+// PreviewForex returns the breakdown of a conversion for a bank at the current rate without recording anything
+func (s *SmartContract) PreviewForex(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string) (*Conversion, error) {
+    return s.convert(ctx, currencyFrom, currencyTo, amount, bank)
+}
+
+// Forex converts an amount for a bank at the current rate and returns the amount after fees
+func (s *SmartContract) Forex(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string) (int, error) {
+
+    conversion, err := s.convert(ctx, currencyFrom, currencyTo, amount, bank)
+    if err != nil {
+        return 0, err
+    }
+
+    return conversion.ConvertedAmount, nil
+}
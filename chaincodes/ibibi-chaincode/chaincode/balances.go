@@ -0,0 +1,230 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// homeCurrency returns the currency of the account's central bank
+func homeCurrency(bankAccountAsset *BankAccountAsset) string {
+	return strings.ToUpper(bankAccountAsset.CentralBank)
+}
+
+// normalizeAccount fills in fields missing from accounts stored by earlier versions.
+// Accounts created before statuses existed are active and
+// accounts created before multi-currency balances hold their funds in the home currency.
+func normalizeAccount(bankAccountAsset *BankAccountAsset) {
+	if bankAccountAsset.Status == "" {
+		bankAccountAsset.Status = StatusActive
+	}
+	if bankAccountAsset.Balances == nil {
+		bankAccountAsset.Balances = map[string]int{}
+		if bankAccountAsset.CentralBank != "" {
+			bankAccountAsset.Balances[homeCurrency(bankAccountAsset)] = bankAccountAsset.Funds
+		}
+	}
+}
+
+// balanceOf returns the account's balance in a currency. An empty currency means the home currency.
+func balanceOf(bankAccountAsset *BankAccountAsset, currency string) int {
+	if currency == "" {
+		currency = homeCurrency(bankAccountAsset)
+	}
+	return bankAccountAsset.Balances[strings.ToUpper(currency)]
+}
+
+// adjustBalance adds delta to the account's balance in a currency, keeping Funds equal to the
+// home currency balance. An account without a central bank takes the first currency credited.
+func adjustBalance(bankAccountAsset *BankAccountAsset, currency string, delta int) {
+	if currency == "" {
+		currency = homeCurrency(bankAccountAsset)
+	}
+	currency = strings.ToUpper(currency)
+
+	if bankAccountAsset.CentralBank == "" {
+		bankAccountAsset.CentralBank = currency
+	}
+	if bankAccountAsset.Balances == nil {
+		bankAccountAsset.Balances = map[string]int{}
+	}
+
+	bankAccountAsset.Balances[currency] += delta
+	if currency == homeCurrency(bankAccountAsset) {
+		bankAccountAsset.Funds = bankAccountAsset.Balances[currency]
+	}
+}
+
+// GetBalance returns the balance of an account in a currency, including credits not yet compacted
+func (s *SmartContract) GetBalance(ctx contractapi.TransactionContextInterface, accountNo string, currency string) (int, error) {
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return 0, err
+	}
+	if currency == "" {
+		currency = homeCurrency(bankAccountAsset)
+	}
+
+	pending, err := s.GetPendingCredits(ctx, accountNo)
+	if err != nil {
+		return 0, err
+	}
+
+	return balanceOf(bankAccountAsset, currency) + pending[strings.ToUpper(currency)], nil
+}
+
+// debitFunds removes funds from the account's balance in a currency.
+// An empty currency means the home currency.
+func (s *SmartContract) debitFunds(ctx contractapi.TransactionContextInterface, accountNo string, currency string, amount int) error {
+	bankAccountAsset, err := s.GetBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return err
+	}
+
+	if err := checkCanMoveFunds(bankAccountAsset, amount); err != nil {
+		return err
+	}
+	if err := checkTransactionLimit(bankAccountAsset, amount); err != nil {
+		return err
+	}
+
+	// Credits not yet compacted into the balance of a high-throughput account cannot be spent
+	if balanceOf(bankAccountAsset, currency) < amount {
+		if bankAccountAsset.HighThroughput {
+			return fmt.Errorf("insufficient funds in the account, pending credits are available once the balance is compacted")
+		}
+		return fmt.Errorf("insufficient funds in the account")
+	}
+
+	adjustBalance(bankAccountAsset, currency, -amount)
+
+	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+}
+
+// ConvertFunds converts part of an account's balance from one currency to another
+// at the forex rate and returns the amount credited in the target currency.
+// The bank's reserves are converted alongside through the central banks, so every currency's supply still balances.
+func (s *SmartContract) ConvertFunds(ctx contractapi.TransactionContextInterface, accountNo string, currencyFrom string, currencyTo string, amount int) (int, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	if strings.EqualFold(currencyFrom, currencyTo) {
+		return 0, fmt.Errorf("cannot convert %s to itself", currencyFrom)
+	}
+
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkCanMoveFunds(bankAccountAsset, amount); err != nil {
+		return 0, err
+	}
+	if err := checkTransactionLimit(bankAccountAsset, amount); err != nil {
+		return 0, err
+	}
+	if balanceOf(bankAccountAsset, currencyFrom) < amount {
+		return 0, fmt.Errorf("insufficient funds in the account")
+	}
+
+	fcn := "ConvertReserves"
+	args := [][]byte{[]byte(fcn), []byte(strings.ToUpper(currencyFrom)), []byte(strings.ToUpper(currencyTo)), []byte(fmt.Sprintf("%d", amount)), []byte("ibibi")}
+
+	response := ctx.GetStub().InvokeChaincode(strings.ToLower(currencyFrom), args, "")
+
+	if response.GetStatus() != 200 {
+		return 0, fmt.Errorf("ibibi to central bank chaincode conversion returned %d. %s", response.GetStatus(), response.GetMessage())
+	}
+
+	converted, err := strconv.Atoi(string(response.GetPayload()))
+	if err != nil {
+		return 0, err
+	}
+
+	// Both balances change in one write, as the account cannot be read back within the transaction
+	adjustBalance(bankAccountAsset, currencyFrom, -amount)
+	adjustBalance(bankAccountAsset, currencyTo, converted)
+
+	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
+	if err != nil {
+		return 0, err
+	}
+
+	return converted, ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+}
+
+// GetTotalBalance sums the balances every account at this bank holds in a currency
+func (s *SmartContract) GetTotalBalance(ctx contractapi.TransactionContextInterface, currency string) (int, error) {
+	// Accounts are stored under plain keys, while every other record uses a composite key
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	total := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		if strings.HasPrefix(queryResponse.Key, "\x00") {
+			continue
+		}
+
+		var bankAccountAsset BankAccountAsset
+		if err := json.Unmarshal(queryResponse.Value, &bankAccountAsset); err != nil {
+			return 0, err
+		}
+		normalizeAccount(&bankAccountAsset)
+
+		total += balanceOf(&bankAccountAsset, currency)
+	}
+
+	// Tax withheld from credits, held outside the customer accounts
+	taxAccountKey, err := ctx.GetStub().CreateCompositeKey("taxaccount", []string{strings.ToUpper(currency)})
+	if err != nil {
+		return 0, err
+	}
+	taxAccountJSON, err := ctx.GetStub().GetState(taxAccountKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read tax account from world state: %v", err)
+	}
+	if taxAccountJSON != nil {
+		var withholdingAccount BankAccountAsset
+		if err := json.Unmarshal(taxAccountJSON, &withholdingAccount); err != nil {
+			return 0, err
+		}
+		normalizeAccount(&withholdingAccount)
+		total += balanceOf(&withholdingAccount, currency)
+	}
+	err = s.eachTaxDelta(ctx, currency, func(key string, withheld int) error {
+		total += withheld
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Credits to high-throughput accounts not yet compacted
+	err = s.eachDelta(ctx, []string{}, func(key string, deltaCurrency string, amount int) error {
+		if strings.EqualFold(deltaCurrency, currency) {
+			total += amount
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
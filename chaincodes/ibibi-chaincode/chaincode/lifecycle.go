@@ -0,0 +1,185 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Account statuses
+const (
+	StatusActive  = "Active"
+	StatusFrozen  = "Frozen"
+	StatusDormant = "Dormant"
+	StatusClosed  = "Closed"
+)
+
+// allowedTransitions lists the statuses an account may move to from each status.
+// Closed is terminal.
+var allowedTransitions = map[string][]string{
+	StatusActive:  {StatusFrozen, StatusDormant, StatusClosed},
+	StatusFrozen:  {StatusActive, StatusClosed},
+	StatusDormant: {StatusActive, StatusFrozen, StatusClosed},
+	StatusClosed:  {},
+}
+
+// StatusChange records a single status transition of a bank account
+type StatusChange struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	ReasonCode string `json:"reasonCode"`
+	ChangedBy  string `json:"changedBy"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// checkCanMoveFunds returns an error if funds may not be moved in or out of the account
+func checkCanMoveFunds(bankAccountAsset *BankAccountAsset, amount int) error {
+	if amount < 0 {
+		return fmt.Errorf("amount cannot be negative")
+	}
+	if bankAccountAsset.Status != StatusActive {
+		return fmt.Errorf("bank account %s is %s, funds cannot be moved", bankAccountAsset.AccountNo, bankAccountAsset.Status)
+	}
+
+	return nil
+}
+
+// checkTransactionLimit returns an error if a debit exceeds the KYC transaction limit of the account
+func checkTransactionLimit(bankAccountAsset *BankAccountAsset, amount int) error {
+	if bankAccountAsset.TransactionLimit > 0 && amount > bankAccountAsset.TransactionLimit {
+		return fmt.Errorf("amount %d exceeds the transaction limit %d of bank account %s", amount, bankAccountAsset.TransactionLimit, bankAccountAsset.AccountNo)
+	}
+
+	return nil
+}
+
+// ChangeAccountStatus moves a bank account to a new status, recording the reason code.
+// Only the bank admin may change account statuses.
+func (s *SmartContract) ChangeAccountStatus(ctx contractapi.TransactionContextInterface, accountNo string, status string, reasonCode string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	if reasonCode == "" {
+		return fmt.Errorf("a reason code is required to change account status")
+	}
+
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return err
+	}
+
+	allowed := false
+	for _, next := range allowedTransitions[bankAccountAsset.Status] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("bank account %s cannot move from %s to %s", accountNo, bankAccountAsset.Status, status)
+	}
+
+	if status == StatusClosed {
+		for currency, balance := range bankAccountAsset.Balances {
+			if balance != 0 {
+				return fmt.Errorf("bank account %s still holds %d %s and cannot be closed", accountNo, balance, currency)
+			}
+		}
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	bankAccountAsset.StatusHistory = append(bankAccountAsset.StatusHistory, StatusChange{
+		From:       bankAccountAsset.Status,
+		To:         status,
+		ReasonCode: reasonCode,
+		ChangedBy:  clientID,
+		Timestamp:  now.Format(time.RFC3339),
+	})
+	bankAccountAsset.Status = status
+	bankAccountAsset.StatusReason = reasonCode
+
+	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+}
+
+// FreezeAccount blocks all fund movements on a bank account
+func (s *SmartContract) FreezeAccount(ctx contractapi.TransactionContextInterface, accountNo string, reasonCode string) error {
+	return s.ChangeAccountStatus(ctx, accountNo, StatusFrozen, reasonCode)
+}
+
+// UnfreezeAccount returns a frozen or dormant bank account to active
+func (s *SmartContract) UnfreezeAccount(ctx contractapi.TransactionContextInterface, accountNo string, reasonCode string) error {
+	return s.ChangeAccountStatus(ctx, accountNo, StatusActive, reasonCode)
+}
+
+// MarkAccountDormant marks an inactive bank account as dormant
+func (s *SmartContract) MarkAccountDormant(ctx contractapi.TransactionContextInterface, accountNo string, reasonCode string) error {
+	return s.ChangeAccountStatus(ctx, accountNo, StatusDormant, reasonCode)
+}
+
+// CloseAccount permanently closes a bank account with zero funds
+func (s *SmartContract) CloseAccount(ctx contractapi.TransactionContextInterface, accountNo string, reasonCode string) error {
+	return s.ChangeAccountStatus(ctx, accountNo, StatusClosed, reasonCode)
+}
+
+// SetKYCLevel records the KYC level of a bank account and the largest amount
+// it may move in a single transaction. A transaction limit of 0 means no limit.
+func (s *SmartContract) SetKYCLevel(ctx contractapi.TransactionContextInterface, accountNo string, kycLevel int, transactionLimit int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	if kycLevel < 0 || transactionLimit < 0 {
+		return fmt.Errorf("kyc level and transaction limit cannot be negative")
+	}
+
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return err
+	}
+
+	bankAccountAsset.KYCLevel = kycLevel
+	bankAccountAsset.TransactionLimit = transactionLimit
+
+	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+}
+
+// readBankAccountAsset retrieves an existing bank account asset, returning an error if it does not exist.
+// Unlike GetBankAccountAsset it never creates the account.
+func (s *SmartContract) readBankAccountAsset(ctx contractapi.TransactionContextInterface, accountNo string) (*BankAccountAsset, error) {
+	bankAccountAssetJSON, err := ctx.GetStub().GetState(accountNo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bank account asset from world state: %v", err)
+	}
+	if bankAccountAssetJSON == nil {
+		return nil, fmt.Errorf("bank account asset with account number %s does not exist", accountNo)
+	}
+
+	var bankAccountAsset BankAccountAsset
+	err = json.Unmarshal(bankAccountAssetJSON, &bankAccountAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizeAccount(&bankAccountAsset)
+
+	return &bankAccountAsset, nil
+}
@@ -0,0 +1,384 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Actions taken when a payment breaches a transfer limit
+const (
+	OnBreachReject = "reject"
+	OnBreachFlag   = "flag"
+)
+
+// Flagged payment statuses
+const (
+	PaymentPendingApproval = "PendingApproval"
+	PaymentApproved        = "Approved"
+	PaymentRejected        = "Rejected"
+)
+
+// TransferLimit caps the outflows of an account or of a currency corridor.
+// A cap of 0 means no cap.
+type TransferLimit struct {
+	Scope          string `json:"scope"` // "account" or "corridor"
+	Key            string `json:"key"`   // account number or corridor such as "USD-INR"
+	PerTransaction int    `json:"perTransaction"`
+	Daily          int    `json:"daily"`
+	Monthly        int    `json:"monthly"`
+	DailyCount     int    `json:"dailyCount"` // maximum number of payments per day
+	OnBreach       string `json:"onBreach"`
+}
+
+// TransferUsage is a running counter of the outflows of an account in a period
+type TransferUsage struct {
+	AccountNo string `json:"accountNo"`
+	Corridor  string `json:"corridor"` // "*" counts every corridor
+	Period    string `json:"period"`   // "2006-01-02" for days, "2006-01" for months
+	Amount    int    `json:"amount"`
+	Count     int    `json:"count"`
+}
+
+// FlaggedPayment is a payment held for manual approval after breaching a limit.
+// The amount is debited from the payer when the payment is flagged.
+type FlaggedPayment struct {
+	PaymentId       string   `json:"paymentId"`
+	CurrencyFrom    string   `json:"currencyFrom"`
+	CurrencyTo      string   `json:"currencyTo"`
+	Amount          int      `json:"amount"`
+	BankAccountFrom string   `json:"bankAccountFrom"`
+	BankTo          string   `json:"bankTo"`
+	BankAccountTo   string   `json:"bankAccountTo"`
+	PaymentType     string   `json:"paymentType"`
+	QuoteId         string   `json:"quoteId"`
+	Breaches        []string `json:"breaches"`
+	Status          string   `json:"status"`
+	Reason          string   `json:"reason"`
+	FlaggedAt       string   `json:"flaggedAt"`
+}
+
+func corridorOf(currencyFrom string, currencyTo string) string {
+	return strings.ToUpper(currencyFrom) + "-" + strings.ToUpper(currencyTo)
+}
+
+// SetAccountLimit configures the transfer limits of a single account
+func (s *SmartContract) SetAccountLimit(ctx contractapi.TransactionContextInterface, accountNo string, perTransaction int, daily int, monthly int, dailyCount int, onBreach string) error {
+	return s.putTransferLimit(ctx, TransferLimit{
+		Scope:          "account",
+		Key:            accountNo,
+		PerTransaction: perTransaction,
+		Daily:          daily,
+		Monthly:        monthly,
+		DailyCount:     dailyCount,
+		OnBreach:       onBreach,
+	})
+}
+
+// SetCorridorLimit configures the transfer limits every account has on a currency corridor
+func (s *SmartContract) SetCorridorLimit(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, perTransaction int, daily int, monthly int, dailyCount int, onBreach string) error {
+	return s.putTransferLimit(ctx, TransferLimit{
+		Scope:          "corridor",
+		Key:            corridorOf(currencyFrom, currencyTo),
+		PerTransaction: perTransaction,
+		Daily:          daily,
+		Monthly:        monthly,
+		DailyCount:     dailyCount,
+		OnBreach:       onBreach,
+	})
+}
+
+func (s *SmartContract) putTransferLimit(ctx contractapi.TransactionContextInterface, limit TransferLimit) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	if limit.PerTransaction < 0 || limit.Daily < 0 || limit.Monthly < 0 || limit.DailyCount < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	if limit.OnBreach != OnBreachReject && limit.OnBreach != OnBreachFlag {
+		return fmt.Errorf("onBreach must be %s or %s", OnBreachReject, OnBreachFlag)
+	}
+
+	limitKey, err := ctx.GetStub().CreateCompositeKey("limit", []string{limit.Scope, limit.Key})
+	if err != nil {
+		return err
+	}
+	limitJSON, err := json.Marshal(limit)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(limitKey, limitJSON)
+}
+
+// GetTransferLimit returns the limit for a scope ("account" or "corridor") and key
+func (s *SmartContract) GetTransferLimit(ctx contractapi.TransactionContextInterface, scope string, key string) (*TransferLimit, error) {
+	limit, err := s.getTransferLimit(ctx, scope, key)
+	if err != nil {
+		return nil, err
+	}
+	if limit == nil {
+		return nil, fmt.Errorf("no %s transfer limit is set for %s", scope, key)
+	}
+
+	return limit, nil
+}
+
+// getTransferLimit returns the limit for a scope and key, or nil if none is set
+func (s *SmartContract) getTransferLimit(ctx contractapi.TransactionContextInterface, scope string, key string) (*TransferLimit, error) {
+	limitKey, err := ctx.GetStub().CreateCompositeKey("limit", []string{scope, key})
+	if err != nil {
+		return nil, err
+	}
+	limitJSON, err := ctx.GetStub().GetState(limitKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transfer limit from world state: %v", err)
+	}
+	if limitJSON == nil {
+		return nil, nil
+	}
+
+	var limit TransferLimit
+	if err := json.Unmarshal(limitJSON, &limit); err != nil {
+		return nil, err
+	}
+
+	return &limit, nil
+}
+
+// GetTransferUsage returns the outflow counter of an account on a corridor for a period.
+// Use corridor "*" for the account's total outflows.
+func (s *SmartContract) GetTransferUsage(ctx contractapi.TransactionContextInterface, accountNo string, corridor string, period string) (*TransferUsage, error) {
+	usageKey, err := ctx.GetStub().CreateCompositeKey("usage", []string{accountNo, corridor, period})
+	if err != nil {
+		return nil, err
+	}
+	usageJSON, err := ctx.GetStub().GetState(usageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transfer usage from world state: %v", err)
+	}
+	if usageJSON == nil {
+		return &TransferUsage{AccountNo: accountNo, Corridor: corridor, Period: period}, nil
+	}
+
+	var usage TransferUsage
+	if err := json.Unmarshal(usageJSON, &usage); err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// checkTransferLimits evaluates a payment against the account and corridor limits.
+// It returns an error if a rejecting limit is breached and
+// the list of breaches if only flagging limits are breached.
+func (s *SmartContract) checkTransferLimits(ctx contractapi.TransactionContextInterface, accountNo string, currencyFrom string, currencyTo string, amount int) ([]string, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	day := now.Format("2006-01-02")
+	month := now.Format("2006-01")
+	corridor := corridorOf(currencyFrom, currencyTo)
+
+	accountLimit, err := s.getTransferLimit(ctx, "account", accountNo)
+	if err != nil {
+		return nil, err
+	}
+	corridorLimit, err := s.getTransferLimit(ctx, "corridor", corridor)
+	if err != nil {
+		return nil, err
+	}
+
+	var breaches []string
+	reject := false
+
+	for _, check := range []struct {
+		limit     *TransferLimit
+		usageOver string
+	}{{accountLimit, "*"}, {corridorLimit, corridor}} {
+		limit := check.limit
+		if limit == nil {
+			continue
+		}
+
+		daily, err := s.GetTransferUsage(ctx, accountNo, check.usageOver, day)
+		if err != nil {
+			return nil, err
+		}
+		monthly, err := s.GetTransferUsage(ctx, accountNo, check.usageOver, month)
+		if err != nil {
+			return nil, err
+		}
+
+		var found []string
+		if limit.PerTransaction > 0 && amount > limit.PerTransaction {
+			found = append(found, fmt.Sprintf("%s %s per-transaction limit %d", limit.Scope, limit.Key, limit.PerTransaction))
+		}
+		if limit.Daily > 0 && daily.Amount+amount > limit.Daily {
+			found = append(found, fmt.Sprintf("%s %s daily limit %d", limit.Scope, limit.Key, limit.Daily))
+		}
+		if limit.Monthly > 0 && monthly.Amount+amount > limit.Monthly {
+			found = append(found, fmt.Sprintf("%s %s monthly limit %d", limit.Scope, limit.Key, limit.Monthly))
+		}
+		if limit.DailyCount > 0 && daily.Count+1 > limit.DailyCount {
+			found = append(found, fmt.Sprintf("%s %s daily payment count %d", limit.Scope, limit.Key, limit.DailyCount))
+		}
+
+		if len(found) > 0 && limit.OnBreach == OnBreachReject {
+			reject = true
+		}
+		breaches = append(breaches, found...)
+	}
+
+	if reject {
+		return nil, fmt.Errorf("payment breaches transfer limits: %s", strings.Join(breaches, "; "))
+	}
+
+	return breaches, nil
+}
+
+// recordTransferUsage adds a payment to the daily and monthly counters of the account
+func (s *SmartContract) recordTransferUsage(ctx contractapi.TransactionContextInterface, accountNo string, currencyFrom string, currencyTo string, amount int) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, corridor := range []string{"*", corridorOf(currencyFrom, currencyTo)} {
+		for _, period := range []string{now.Format("2006-01-02"), now.Format("2006-01")} {
+			usage, err := s.GetTransferUsage(ctx, accountNo, corridor, period)
+			if err != nil {
+				return err
+			}
+			usage.Amount += amount
+			usage.Count++
+
+			usageKey, err := ctx.GetStub().CreateCompositeKey("usage", []string{accountNo, corridor, period})
+			if err != nil {
+				return err
+			}
+			usageJSON, err := json.Marshal(usage)
+			if err != nil {
+				return err
+			}
+			if err := ctx.GetStub().PutState(usageKey, usageJSON); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetFlaggedPayment retrieves a payment held for manual approval
+func (s *SmartContract) GetFlaggedPayment(ctx contractapi.TransactionContextInterface, paymentId string) (*FlaggedPayment, error) {
+	paymentKey, err := ctx.GetStub().CreateCompositeKey("flagged", []string{paymentId})
+	if err != nil {
+		return nil, err
+	}
+	paymentJSON, err := ctx.GetStub().GetState(paymentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flagged payment from world state: %v", err)
+	}
+	if paymentJSON == nil {
+		return nil, fmt.Errorf("flagged payment %s does not exist", paymentId)
+	}
+
+	var payment FlaggedPayment
+	if err := json.Unmarshal(paymentJSON, &payment); err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// GetFlaggedPayments lists all payments that were flagged for manual approval
+func (s *SmartContract) GetFlaggedPayments(ctx contractapi.TransactionContextInterface) ([]FlaggedPayment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("flagged", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	payments := []FlaggedPayment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var payment FlaggedPayment
+		if err := json.Unmarshal(queryResponse.Value, &payment); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+func (s *SmartContract) putFlaggedPayment(ctx contractapi.TransactionContextInterface, payment *FlaggedPayment) error {
+	paymentKey, err := ctx.GetStub().CreateCompositeKey("flagged", []string{payment.PaymentId})
+	if err != nil {
+		return err
+	}
+	paymentJSON, err := json.Marshal(payment)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(paymentKey, paymentJSON)
+}
+
+// ApproveFlaggedPayment releases a flagged payment to its destination
+func (s *SmartContract) ApproveFlaggedPayment(ctx contractapi.TransactionContextInterface, paymentId string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	payment, err := s.GetFlaggedPayment(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+	if payment.Status != PaymentPendingApproval {
+		return fmt.Errorf("flagged payment %s is already %s", paymentId, payment.Status)
+	}
+
+	if err := s.recordTransferUsage(ctx, payment.BankAccountFrom, payment.CurrencyFrom, payment.CurrencyTo, payment.Amount); err != nil {
+		return err
+	}
+	if err := s.settlePayment(ctx, payment.PaymentId, payment.CurrencyFrom, payment.CurrencyTo, payment.Amount, payment.BankAccountFrom, payment.BankTo, payment.BankAccountTo, payment.PaymentType, payment.QuoteId); err != nil {
+		return err
+	}
+
+	payment.Status = PaymentApproved
+
+	return s.putFlaggedPayment(ctx, payment)
+}
+
+// RejectFlaggedPayment cancels a flagged payment and returns the held amount to the payer
+func (s *SmartContract) RejectFlaggedPayment(ctx contractapi.TransactionContextInterface, paymentId string, reason string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	payment, err := s.GetFlaggedPayment(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+	if payment.Status != PaymentPendingApproval {
+		return fmt.Errorf("flagged payment %s is already %s", paymentId, payment.Status)
+	}
+
+	if err := s.refundFunds(ctx, payment.BankAccountFrom, payment.CurrencyFrom, payment.Amount); err != nil {
+		return err
+	}
+
+	payment.Status = PaymentRejected
+	payment.Reason = reason
+
+	return s.putFlaggedPayment(ctx, payment)
+}
@@ -0,0 +1,267 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Correspondent account kinds. A nostro is this central bank's record of its account at another
+// central bank, in the other currency. A vostro is the account another central bank holds here, in this currency.
+const (
+	AccountNostro = "nostro"
+	AccountVostro = "vostro"
+)
+
+// CorrespondentAccount is a nostro or vostro account between this central bank and another
+type CorrespondentAccount struct {
+	CentralBank string `json:"centralBank"` // the other central bank
+	Kind        string `json:"kind"`
+	Currency    string `json:"currency"`
+	Balance     int    `json:"balance"`
+}
+
+// CorrespondentReconciliation compares each side's record of the same account
+type CorrespondentReconciliation struct {
+	CentralBank  string `json:"centralBank"`
+	Account      string `json:"account"` // whose currency the account is held in
+	OwnBalance   int    `json:"ownBalance"`
+	TheirBalance int    `json:"theirBalance"`
+	Mismatch     int    `json:"mismatch"`
+}
+
+// OpenVostro opens the account another central bank holds at this one with an initial balance
+// and records the matching nostro on the other central bank's ledger
+func (s *SmartContract) OpenVostro(ctx contractapi.TransactionContextInterface, centralBank string, initialBalance int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if initialBalance < 0 {
+		return fmt.Errorf("initial balance cannot be negative")
+	}
+
+	vostro, err := s.readCorrespondent(ctx, AccountVostro, centralBank)
+	if err != nil {
+		return err
+	}
+	if vostro != nil {
+		return fmt.Errorf("a vostro account for %s is already open", centralBank)
+	}
+
+	return s.fundVostro(ctx, centralBank, initialBalance)
+}
+
+// FundVostro adds funds to the account another central bank holds at this one
+// and records the matching nostro credit on the other central bank's ledger
+func (s *SmartContract) FundVostro(ctx contractapi.TransactionContextInterface, centralBank string, amount int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	if _, err := s.getCorrespondent(ctx, AccountVostro, centralBank); err != nil {
+		return err
+	}
+
+	return s.fundVostro(ctx, centralBank, amount)
+}
+
+// fundVostro issues new currency into another central bank's vostro account
+func (s *SmartContract) fundVostro(ctx contractapi.TransactionContextInterface, centralBank string, amount int) error {
+	if err := s.adjustCorrespondent(ctx, AccountVostro, centralBank, amount); err != nil {
+		return err
+	}
+	if err := s.adjustSupply(ctx, amount); err != nil {
+		return err
+	}
+
+	args := [][]byte{[]byte("CreditNostro"), []byte(strings.ToLower(centralBankCurrency)), []byte(fmt.Sprintf("%d", amount))}
+
+	response := ctx.GetStub().InvokeChaincode(strings.ToLower(centralBank), args, "")
+
+	if response.GetStatus() != 200 {
+		return fmt.Errorf("%s chaincode nostro credit returned %d. %s", centralBank, response.GetStatus(), response.GetMessage())
+	}
+
+	return nil
+}
+
+// CreditNostro records funds added to this central bank's account at another central bank.
+// It is invoked by the other central bank's FundVostro on behalf of that central bank's admin,
+// whose MSP must be registered with RegisterCorrespondentMSP.
+func (s *SmartContract) CreditNostro(ctx contractapi.TransactionContextInterface, centralBank string, amount int) error {
+	if err := s.requireCorrespondent(ctx, centralBank); err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	return s.adjustCorrespondent(ctx, AccountNostro, centralBank, amount)
+}
+
+// RegisterCorrespondentMSP records an MSP whose members act for another central bank.
+// Payments that central bank forwards here are submitted by clients of its member banks,
+// so their MSPs must be registered as well as the central bank's own.
+func (s *SmartContract) RegisterCorrespondentMSP(ctx contractapi.TransactionContextInterface, centralBank string, mspID string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	mspKey, err := ctx.GetStub().CreateCompositeKey("correspondentmsp", []string{strings.ToLower(centralBank), mspID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(mspKey, []byte(mspID))
+}
+
+// requireCorrespondent returns an error unless the caller belongs to an MSP registered for another central bank.
+// That central bank invokes this chaincode on behalf of its clients, whose identity the call keeps.
+func (s *SmartContract) requireCorrespondent(ctx contractapi.TransactionContextInterface, centralBank string) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP: %v", err)
+	}
+	mspKey, err := ctx.GetStub().CreateCompositeKey("correspondentmsp", []string{strings.ToLower(centralBank), mspID})
+	if err != nil {
+		return err
+	}
+	registered, err := ctx.GetStub().GetState(mspKey)
+	if err != nil {
+		return fmt.Errorf("failed to read correspondent MSP from world state: %v", err)
+	}
+	if registered == nil {
+		return fmt.Errorf("caller's MSP %s does not act for central bank %s", mspID, centralBank)
+	}
+
+	return nil
+}
+
+// GetCorrespondentAccount returns this central bank's nostro or vostro account with another central bank
+func (s *SmartContract) GetCorrespondentAccount(ctx contractapi.TransactionContextInterface, kind string, centralBank string) (*CorrespondentAccount, error) {
+	return s.getCorrespondent(ctx, kind, centralBank)
+}
+
+// ReconcileCorrespondent compares both sides of the nostro and vostro accounts with another central bank.
+// A non-zero mismatch means the two ledgers disagree about the account balance.
+func (s *SmartContract) ReconcileCorrespondent(ctx contractapi.TransactionContextInterface, centralBank string) ([]CorrespondentReconciliation, error) {
+	self := strings.ToLower(centralBankCurrency)
+
+	reconciliations := []CorrespondentReconciliation{}
+	for _, side := range []struct{ own, theirs, account string }{
+		{AccountNostro, AccountVostro, strings.ToUpper(centralBank)},
+		{AccountVostro, AccountNostro, centralBankCurrency},
+	} {
+		own, err := s.readCorrespondent(ctx, side.own, centralBank)
+		if err != nil {
+			return nil, err
+		}
+		ownBalance := 0
+		if own != nil {
+			ownBalance = own.Balance
+		}
+
+		args := [][]byte{[]byte("GetCorrespondentAccount"), []byte(side.theirs), []byte(self)}
+
+		response := ctx.GetStub().InvokeChaincode(strings.ToLower(centralBank), args, "")
+
+		// An account the other side has no record of counts as a zero balance there
+		theirBalance := 0
+		if response.GetStatus() == 200 {
+			var theirs CorrespondentAccount
+			if err := json.Unmarshal(response.GetPayload(), &theirs); err != nil {
+				return nil, err
+			}
+			theirBalance = theirs.Balance
+		}
+
+		reconciliations = append(reconciliations, CorrespondentReconciliation{
+			CentralBank:  strings.ToLower(centralBank),
+			Account:      side.own + " in " + side.account,
+			OwnBalance:   ownBalance,
+			TheirBalance: theirBalance,
+			Mismatch:     ownBalance - theirBalance,
+		})
+	}
+
+	return reconciliations, nil
+}
+
+// getCorrespondent reads a correspondent account, returning an error when it has not been opened
+func (s *SmartContract) getCorrespondent(ctx contractapi.TransactionContextInterface, kind string, centralBank string) (*CorrespondentAccount, error) {
+	account, err := s.readCorrespondent(ctx, kind, centralBank)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("no %s account with %s is open", kind, centralBank)
+	}
+
+	return account, nil
+}
+
+// adjustCorrespondent adds delta to a correspondent account, opening it if needed.
+// Debits that would take the account below zero are rejected.
+func (s *SmartContract) adjustCorrespondent(ctx contractapi.TransactionContextInterface, kind string, centralBank string, delta int) error {
+	account, err := s.readCorrespondent(ctx, kind, centralBank)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		currency := centralBankCurrency
+		if kind == AccountNostro {
+			currency = strings.ToUpper(centralBank)
+		}
+		account = &CorrespondentAccount{
+			CentralBank: strings.ToLower(centralBank),
+			Kind:        kind,
+			Currency:    currency,
+		}
+	}
+
+	if account.Balance+delta < 0 {
+		return fmt.Errorf("insufficient funds in the %s account with %s", kind, centralBank)
+	}
+	account.Balance += delta
+
+	accountKey, err := ctx.GetStub().CreateCompositeKey("correspondent", []string{kind, account.CentralBank})
+	if err != nil {
+		return err
+	}
+	accountJSON, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(accountKey, accountJSON)
+}
+
+func (s *SmartContract) readCorrespondent(ctx contractapi.TransactionContextInterface, kind string, centralBank string) (*CorrespondentAccount, error) {
+	if kind != AccountNostro && kind != AccountVostro {
+		return nil, fmt.Errorf("account kind must be %s or %s", AccountNostro, AccountVostro)
+	}
+
+	accountKey, err := ctx.GetStub().CreateCompositeKey("correspondent", []string{kind, strings.ToLower(centralBank)})
+	if err != nil {
+		return nil, err
+	}
+	accountJSON, err := ctx.GetStub().GetState(accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s account from world state: %v", kind, err)
+	}
+	if accountJSON == nil {
+		return nil, nil
+	}
+
+	var account CorrespondentAccount
+	if err := json.Unmarshal(accountJSON, &account); err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
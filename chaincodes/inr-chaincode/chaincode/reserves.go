@@ -0,0 +1,231 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ReserveAccount is the balance a member commercial bank holds at this central bank in its currency
+type ReserveAccount struct {
+	Bank     string `json:"bank"`
+	Currency string `json:"currency"`
+	Balance  int    `json:"balance"`
+}
+
+// RegisterMemberBank opens a reserve account for a commercial bank with an initial deposit
+func (s *SmartContract) RegisterMemberBank(ctx contractapi.TransactionContextInterface, bank string, initialReserve int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if initialReserve < 0 {
+		return fmt.Errorf("initial reserve cannot be negative")
+	}
+
+	reserve, err := s.readReserve(ctx, bank)
+	if err != nil {
+		return err
+	}
+	if reserve != nil {
+		return fmt.Errorf("bank %s is already a member", bank)
+	}
+
+	if err := s.adjustSupply(ctx, initialReserve); err != nil {
+		return err
+	}
+
+	return s.putReserve(ctx, &ReserveAccount{
+		Bank:     strings.ToLower(bank),
+		Currency: centralBankCurrency,
+		Balance:  initialReserve,
+	})
+}
+
+// RegisterMemberBankMSP records an MSP whose members act for a member bank.
+// Members of a registered MSP may move the bank's reserves and submit its cross-border payments.
+func (s *SmartContract) RegisterMemberBankMSP(ctx contractapi.TransactionContextInterface, bank string, mspID string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if _, err := s.GetReserve(ctx, bank); err != nil {
+		return err
+	}
+
+	mspKey, err := ctx.GetStub().CreateCompositeKey("memberbankmsp", []string{strings.ToLower(bank), mspID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(mspKey, []byte(mspID))
+}
+
+// requireMemberBank returns an error unless the caller belongs to an MSP registered for a member bank.
+// Member banks invoke this chaincode on behalf of their own clients, whose identity the call keeps.
+func (s *SmartContract) requireMemberBank(ctx contractapi.TransactionContextInterface, bank string) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP: %v", err)
+	}
+	mspKey, err := ctx.GetStub().CreateCompositeKey("memberbankmsp", []string{strings.ToLower(bank), mspID})
+	if err != nil {
+		return err
+	}
+	registered, err := ctx.GetStub().GetState(mspKey)
+	if err != nil {
+		return fmt.Errorf("failed to read member bank MSP from world state: %v", err)
+	}
+	if registered == nil {
+		return fmt.Errorf("caller's MSP %s does not act for bank %s", mspID, bank)
+	}
+
+	return nil
+}
+
+// GetReserve returns the reserve account of a member bank
+func (s *SmartContract) GetReserve(ctx contractapi.TransactionContextInterface, bank string) (*ReserveAccount, error) {
+	reserve, err := s.readReserve(ctx, bank)
+	if err != nil {
+		return nil, err
+	}
+	if reserve == nil {
+		return nil, fmt.Errorf("bank %s is not a member of the %s central bank", bank, centralBankCurrency)
+	}
+
+	return reserve, nil
+}
+
+// Mint issues new currency into a member bank's reserve
+func (s *SmartContract) Mint(ctx contractapi.TransactionContextInterface, bank string, amount int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	if err := s.creditReserve(ctx, bank, amount); err != nil {
+		return err
+	}
+
+	return s.adjustSupply(ctx, amount)
+}
+
+// Burn withdraws currency from a member bank's reserve and removes it from circulation
+func (s *SmartContract) Burn(ctx contractapi.TransactionContextInterface, bank string, amount int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	if err := s.debitReserve(ctx, bank, amount); err != nil {
+		return err
+	}
+
+	return s.adjustSupply(ctx, -amount)
+}
+
+// TransferReserves moves reserves between two member banks to settle a domestic interbank payment.
+// Only the sending bank or the admin can move its reserves.
+func (s *SmartContract) TransferReserves(ctx contractapi.TransactionContextInterface, bankFrom string, bankTo string, amount int) error {
+	if s.requireRole(ctx, RoleAdmin) != nil {
+		if err := s.requireMemberBank(ctx, bankFrom); err != nil {
+			return err
+		}
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if strings.EqualFold(bankFrom, bankTo) {
+		return nil
+	}
+
+	if err := s.debitReserve(ctx, bankFrom, amount); err != nil {
+		return err
+	}
+
+	return s.creditReserve(ctx, bankTo, amount)
+}
+
+// checkReserve returns an error unless a member bank's reserve covers an amount, without debiting it
+func (s *SmartContract) checkReserve(ctx contractapi.TransactionContextInterface, bank string, amount int) error {
+	reserve, err := s.GetReserve(ctx, bank)
+	if err != nil {
+		return err
+	}
+	if reserve.Balance < amount {
+		return fmt.Errorf("insufficient %s reserves for bank %s", centralBankCurrency, bank)
+	}
+
+	return nil
+}
+
+// debitReserve removes funds from a member bank's reserve, rejecting the debit when the reserve is insufficient
+func (s *SmartContract) debitReserve(ctx contractapi.TransactionContextInterface, bank string, amount int) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	reserve, err := s.GetReserve(ctx, bank)
+	if err != nil {
+		return err
+	}
+	if reserve.Balance < amount {
+		return fmt.Errorf("insufficient %s reserves for bank %s", centralBankCurrency, bank)
+	}
+
+	reserve.Balance -= amount
+
+	return s.putReserve(ctx, reserve)
+}
+
+// creditReserve adds funds to a member bank's reserve
+func (s *SmartContract) creditReserve(ctx contractapi.TransactionContextInterface, bank string, amount int) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	reserve, err := s.GetReserve(ctx, bank)
+	if err != nil {
+		return err
+	}
+
+	reserve.Balance += amount
+
+	return s.putReserve(ctx, reserve)
+}
+
+func (s *SmartContract) readReserve(ctx contractapi.TransactionContextInterface, bank string) (*ReserveAccount, error) {
+	reserveKey, err := ctx.GetStub().CreateCompositeKey("reserve", []string{strings.ToLower(bank)})
+	if err != nil {
+		return nil, err
+	}
+	reserveJSON, err := ctx.GetStub().GetState(reserveKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reserve from world state: %v", err)
+	}
+	if reserveJSON == nil {
+		return nil, nil
+	}
+
+	var reserve ReserveAccount
+	if err := json.Unmarshal(reserveJSON, &reserve); err != nil {
+		return nil, err
+	}
+
+	return &reserve, nil
+}
+
+func (s *SmartContract) putReserve(ctx contractapi.TransactionContextInterface, reserve *ReserveAccount) error {
+	reserveKey, err := ctx.GetStub().CreateCompositeKey("reserve", []string{reserve.Bank})
+	if err != nil {
+		return err
+	}
+	reserveJSON, err := json.Marshal(reserve)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(reserveKey, reserveJSON)
+}
@@ -0,0 +1,59 @@
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestTransferReservesRequiresTheSendingBank(t *testing.T) {
+	s := &SmartContract{}
+	l := newLedger(t)
+	client := testIdentity{id: "adfc-client", mspID: "ADFCMSP"}
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.InitLedger(ctx)
+	})
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		for _, bank := range []string{"adfc", "ibibi"} {
+			if err := s.RegisterMemberBank(ctx, bank, 100); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	transfer := func(bankFrom string, bankTo string, amount int) error {
+		return l.submit(t, client, func(ctx contractapi.TransactionContextInterface) error {
+			return s.TransferReserves(ctx, bankFrom, bankTo, amount)
+		})
+	}
+	if err := transfer("adfc", "ibibi", 10); err == nil {
+		t.Fatalf("transfer by an unregistered MSP was accepted")
+	}
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.RegisterMemberBankMSP(ctx, "adfc", client.mspID)
+	})
+	if err := transfer("ibibi", "adfc", 10); err == nil {
+		t.Fatalf("transfer out of another bank's reserve was accepted")
+	}
+	if err := transfer("adfc", "ibibi", -10); err == nil {
+		t.Fatalf("negative transfer was accepted")
+	}
+	if err := transfer("adfc", "ibibi", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		for bank, balance := range map[string]int{"adfc": 90, "ibibi": 110} {
+			reserve, err := s.GetReserve(ctx, bank)
+			if err != nil {
+				return err
+			}
+			if reserve.Balance != balance {
+				t.Errorf("%s reserve balance %d, want %d", bank, reserve.Balance, balance)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,180 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// BankSupply compares a member bank's reserve with the balances its customers hold in this currency
+type BankSupply struct {
+	Bank            string `json:"bank"`
+	Reserve         int    `json:"reserve"`
+	AccountBalances int    `json:"accountBalances"`
+	Discrepancy     int    `json:"discrepancy"` // reserve minus account balances
+}
+
+// SupplyAudit accounts for every unit of this central bank's currency.
+// Issued supply should equal the balances held in member bank accounts, in other central banks'
+// vostro accounts and in payments still in transit. Any difference is reported as a discrepancy.
+type SupplyAudit struct {
+	Currency           string       `json:"currency"`
+	AuditedAt          string       `json:"auditedAt"`
+	Issued             int          `json:"issued"`
+	Reserves           int          `json:"reserves"`
+	Vostro             int          `json:"vostro"`
+	InTransit          int          `json:"inTransit"` // held for review or awaiting batch settlement
+	AccountBalances    int          `json:"accountBalances"`
+	Banks              []BankSupply `json:"banks"`
+	ReserveDiscrepancy int          `json:"reserveDiscrepancy"` // issued minus reserves, vostro and in transit
+	Discrepancy        int          `json:"discrepancy"`        // issued minus account balances, vostro and in transit
+}
+
+// GetIssuedSupply returns the amount of this central bank's currency minted and not burned
+func (s *SmartContract) GetIssuedSupply(ctx contractapi.TransactionContextInterface) (int, error) {
+	supplyKey, err := ctx.GetStub().CreateCompositeKey("supply", []string{centralBankCurrency})
+	if err != nil {
+		return 0, err
+	}
+	supplyJSON, err := ctx.GetStub().GetState(supplyKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read issued supply from world state: %v", err)
+	}
+	if supplyJSON == nil {
+		return 0, nil
+	}
+
+	var supply int
+	if err := json.Unmarshal(supplyJSON, &supply); err != nil {
+		return 0, err
+	}
+
+	return supply, nil
+}
+
+// adjustSupply records currency entering or leaving circulation
+func (s *SmartContract) adjustSupply(ctx contractapi.TransactionContextInterface, delta int) error {
+	supply, err := s.GetIssuedSupply(ctx)
+	if err != nil {
+		return err
+	}
+	if supply+delta < 0 {
+		return fmt.Errorf("cannot burn more %s than has been issued", centralBankCurrency)
+	}
+
+	supplyKey, err := ctx.GetStub().CreateCompositeKey("supply", []string{centralBankCurrency})
+	if err != nil {
+		return err
+	}
+	supplyJSON, err := json.Marshal(supply + delta)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(supplyKey, supplyJSON)
+}
+
+// AuditSupply sums the balances held in this currency across every member bank and compares them
+// with the issued supply, the member reserves, the vostro accounts and the payments in transit
+func (s *SmartContract) AuditSupply(ctx contractapi.TransactionContextInterface) (*SupplyAudit, error) {
+	issued, err := s.GetIssuedSupply(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := SupplyAudit{
+		Currency:  centralBankCurrency,
+		AuditedAt: now.Format(time.RFC3339),
+		Issued:    issued,
+		Banks:     []BankSupply{},
+	}
+
+	reserveIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("reserve", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer reserveIterator.Close()
+
+	for reserveIterator.HasNext() {
+		queryResponse, err := reserveIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var reserve ReserveAccount
+		if err := json.Unmarshal(queryResponse.Value, &reserve); err != nil {
+			return nil, err
+		}
+
+		args := [][]byte{[]byte("GetTotalBalance"), []byte(centralBankCurrency)}
+
+		response := ctx.GetStub().InvokeChaincode(reserve.Bank, args, "")
+
+		if response.GetStatus() != 200 {
+			return nil, fmt.Errorf("%s chaincode total balance returned %d. %s", reserve.Bank, response.GetStatus(), response.GetMessage())
+		}
+
+		balances, err := strconv.Atoi(string(response.GetPayload()))
+		if err != nil {
+			return nil, err
+		}
+
+		audit.Reserves += reserve.Balance
+		audit.AccountBalances += balances
+		audit.Banks = append(audit.Banks, BankSupply{
+			Bank:            reserve.Bank,
+			Reserve:         reserve.Balance,
+			AccountBalances: balances,
+			Discrepancy:     reserve.Balance - balances,
+		})
+	}
+
+	vostroIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("correspondent", []string{AccountVostro})
+	if err != nil {
+		return nil, err
+	}
+	defer vostroIterator.Close()
+
+	for vostroIterator.HasNext() {
+		queryResponse, err := vostroIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var vostro CorrespondentAccount
+		if err := json.Unmarshal(queryResponse.Value, &vostro); err != nil {
+			return nil, err
+		}
+		audit.Vostro += vostro.Balance
+	}
+
+	held, err := s.GetHeldPayments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range held {
+		if payment.Status == PaymentPendingReview {
+			audit.InTransit += payment.Amount
+		}
+	}
+
+	obligations, err := s.GetObligations(ctx, ObligationPending)
+	if err != nil {
+		return nil, err
+	}
+	for _, obligation := range obligations {
+		audit.InTransit += obligation.Amount
+	}
+
+	audit.ReserveDiscrepancy = issued - audit.Reserves - audit.Vostro - audit.InTransit
+	audit.Discrepancy = issued - audit.AccountBalances - audit.Vostro - audit.InTransit
+
+	return &audit, nil
+}
@@ -0,0 +1,357 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Watchlist entry types
+const (
+	WatchName    = "name"
+	WatchAccount = "account"
+	WatchCountry = "country"
+)
+
+// Held payment statuses
+const (
+	PaymentPendingReview = "PendingReview"
+	PaymentReleased      = "Released"
+	PaymentRefunded      = "Refunded"
+)
+
+// currencyCountries maps each currency to the country of its central bank
+var currencyCountries = map[string]string{
+	"USD": "US",
+	"INR": "IN",
+}
+
+func countryOf(currency string) string {
+	return currencyCountries[strings.ToUpper(currency)]
+}
+
+// WatchlistEntry is a sanctioned name, account number or country
+type WatchlistEntry struct {
+	EntryType string `json:"entryType"`
+	Value     string `json:"value"`
+	Note      string `json:"note"`
+	AddedBy   string `json:"addedBy"`
+	AddedAt   string `json:"addedAt"`
+}
+
+// HeldPayment is a cross-border payment held for compliance review.
+// The payer has already been debited by their commercial bank.
+type HeldPayment struct {
+	PaymentId       string   `json:"paymentId"`
+	CurrencyFrom    string   `json:"currencyFrom"`
+	CurrencyTo      string   `json:"currencyTo"`
+	Amount          int      `json:"amount"`
+	Bank            string   `json:"bank"`
+	BankAccount     string   `json:"bankAccount"`
+	BankFrom        string   `json:"bankFrom"`
+	BankAccountFrom string   `json:"bankAccountFrom"`
+	PayerName       string   `json:"payerName"`
+	PayeeName       string   `json:"payeeName"`
+	PaymentType     string   `json:"paymentType"`
+	PayerCountry    string   `json:"payerCountry"`
+	QuoteId         string   `json:"quoteId"`
+	Matches         []string `json:"matches"`
+	Status          string   `json:"status"`
+	Reason          string   `json:"reason"`
+	HeldAt          string   `json:"heldAt"`
+	ReviewedBy      string   `json:"reviewedBy"`
+}
+
+func normalizeWatchValue(value string) string {
+	return strings.ToUpper(strings.TrimSpace(value))
+}
+
+// AddWatchlistEntry adds a name, account number or country to the watchlist
+func (s *SmartContract) AddWatchlistEntry(ctx contractapi.TransactionContextInterface, entryType string, value string, note string) error {
+	if err := s.requireRole(ctx, RoleCompliance); err != nil {
+		return err
+	}
+	if entryType != WatchName && entryType != WatchAccount && entryType != WatchCountry {
+		return fmt.Errorf("entry type must be %s, %s or %s", WatchName, WatchAccount, WatchCountry)
+	}
+	if normalizeWatchValue(value) == "" {
+		return fmt.Errorf("watchlist value cannot be empty")
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry := WatchlistEntry{
+		EntryType: entryType,
+		Value:     strings.TrimSpace(value),
+		Note:      note,
+		AddedBy:   clientID,
+		AddedAt:   now.Format(time.RFC3339),
+	}
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey("watchlist", []string{entryType, normalizeWatchValue(value)})
+	if err != nil {
+		return err
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(entryKey, entryJSON)
+}
+
+// RemoveWatchlistEntry removes a name, account number or country from the watchlist
+func (s *SmartContract) RemoveWatchlistEntry(ctx contractapi.TransactionContextInterface, entryType string, value string) error {
+	if err := s.requireRole(ctx, RoleCompliance); err != nil {
+		return err
+	}
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey("watchlist", []string{entryType, normalizeWatchValue(value)})
+	if err != nil {
+		return err
+	}
+	entryJSON, err := ctx.GetStub().GetState(entryKey)
+	if err != nil {
+		return fmt.Errorf("failed to read watchlist entry from world state: %v", err)
+	}
+	if entryJSON == nil {
+		return fmt.Errorf("%s %s is not on the watchlist", entryType, value)
+	}
+
+	return ctx.GetStub().DelState(entryKey)
+}
+
+// GetWatchlist returns every watchlist entry
+func (s *SmartContract) GetWatchlist(ctx contractapi.TransactionContextInterface) ([]WatchlistEntry, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("watchlist", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	entries := []WatchlistEntry{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry WatchlistEntry
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// screenPayment returns a description of every watchlist entry matched by the payment parties
+func (s *SmartContract) screenPayment(ctx contractapi.TransactionContextInterface, names []string, accounts []string, countries []string) ([]string, error) {
+	var matches []string
+
+	for _, check := range []struct {
+		entryType string
+		values    []string
+	}{{WatchName, names}, {WatchAccount, accounts}, {WatchCountry, countries}} {
+		for _, value := range check.values {
+			if normalizeWatchValue(value) == "" {
+				continue
+			}
+
+			entryKey, err := ctx.GetStub().CreateCompositeKey("watchlist", []string{check.entryType, normalizeWatchValue(value)})
+			if err != nil {
+				return nil, err
+			}
+			entryJSON, err := ctx.GetStub().GetState(entryKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read watchlist entry from world state: %v", err)
+			}
+			if entryJSON != nil {
+				matches = append(matches, fmt.Sprintf("%s %s", check.entryType, value))
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// getAccountOwner asks a commercial bank for the owner of an account
+func (s *SmartContract) getAccountOwner(ctx contractapi.TransactionContextInterface, bank string, bankAccount string) (string, error) {
+	args := [][]byte{[]byte("GetAccountOwner"), []byte(bankAccount)}
+
+	response := ctx.GetStub().InvokeChaincode(strings.ToLower(bank), args, "")
+
+	if response.GetStatus() != 200 {
+		return "", fmt.Errorf("%s chaincode get account owner invoke returned %d. %s", bank, response.GetStatus(), response.GetMessage())
+	}
+
+	return string(response.GetPayload()), nil
+}
+
+// holdPayment stores a payment for compliance review and emits a PaymentHeld event
+func (s *SmartContract) holdPayment(ctx contractapi.TransactionContextInterface, payment HeldPayment) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	paymentKey, err := ctx.GetStub().CreateCompositeKey("held", []string{payment.PaymentId})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(paymentKey)
+	if err != nil {
+		return fmt.Errorf("failed to read held payment from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("payment %s is already held", payment.PaymentId)
+	}
+
+	payment.Status = PaymentPendingReview
+	payment.HeldAt = now.Format(time.RFC3339)
+
+	if err := s.putHeldPayment(ctx, &payment); err != nil {
+		return err
+	}
+
+	paymentJSON, err := json.Marshal(payment)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent("PaymentHeld", paymentJSON)
+}
+
+func (s *SmartContract) putHeldPayment(ctx contractapi.TransactionContextInterface, payment *HeldPayment) error {
+	paymentKey, err := ctx.GetStub().CreateCompositeKey("held", []string{payment.PaymentId})
+	if err != nil {
+		return err
+	}
+	paymentJSON, err := json.Marshal(payment)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(paymentKey, paymentJSON)
+}
+
+// GetHeldPayment retrieves a payment held for compliance review
+func (s *SmartContract) GetHeldPayment(ctx contractapi.TransactionContextInterface, paymentId string) (*HeldPayment, error) {
+	paymentKey, err := ctx.GetStub().CreateCompositeKey("held", []string{paymentId})
+	if err != nil {
+		return nil, err
+	}
+	paymentJSON, err := ctx.GetStub().GetState(paymentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read held payment from world state: %v", err)
+	}
+	if paymentJSON == nil {
+		return nil, fmt.Errorf("held payment %s does not exist", paymentId)
+	}
+
+	var payment HeldPayment
+	if err := json.Unmarshal(paymentJSON, &payment); err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// GetHeldPayments lists every payment that was held for compliance review
+func (s *SmartContract) GetHeldPayments(ctx contractapi.TransactionContextInterface) ([]HeldPayment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("held", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	payments := []HeldPayment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var payment HeldPayment
+		if err := json.Unmarshal(queryResponse.Value, &payment); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+// reviewHeldPayment loads a payment pending review and records the reviewer
+func (s *SmartContract) reviewHeldPayment(ctx contractapi.TransactionContextInterface, paymentId string) (*HeldPayment, error) {
+	if err := s.requireRole(ctx, RoleCompliance); err != nil {
+		return nil, err
+	}
+
+	payment, err := s.GetHeldPayment(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != PaymentPendingReview {
+		return nil, fmt.Errorf("held payment %s is already %s", paymentId, payment.Status)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+	payment.ReviewedBy = clientID
+
+	return payment, nil
+}
+
+// ApproveHeldPayment releases a held payment to its destination.
+// A quoted payment is converted at its quote, which fails once the quote has expired
+// or when the compliance officer belongs to another organization than the paying bank.
+func (s *SmartContract) ApproveHeldPayment(ctx contractapi.TransactionContextInterface, paymentId string) error {
+	payment, err := s.reviewHeldPayment(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.forwardPayment(ctx, payment.PaymentId, payment.CurrencyFrom, payment.CurrencyTo, payment.Amount, payment.QuoteId, payment.Bank, payment.BankAccount, payment.BankFrom, payment.BankAccountFrom, payment.PaymentType, payment.PayerCountry); err != nil {
+		return err
+	}
+
+	payment.Status = PaymentReleased
+
+	return s.putHeldPayment(ctx, payment)
+}
+
+// RejectHeldPayment refunds a held payment to the payer's account.
+// The sending bank's reserve is only debited once a payment is forwarded, so it is left as is.
+func (s *SmartContract) RejectHeldPayment(ctx contractapi.TransactionContextInterface, paymentId string, reason string) error {
+	payment, err := s.reviewHeldPayment(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+
+	args := [][]byte{[]byte("RefundFunds"), []byte(payment.BankAccountFrom), []byte(payment.CurrencyFrom), []byte(fmt.Sprintf("%d", payment.Amount))}
+
+	response := ctx.GetStub().InvokeChaincode(strings.ToLower(payment.BankFrom), args, "")
+
+	if response.GetStatus() != 200 {
+		return fmt.Errorf("%s chaincode refund invoke returned %d. %s", payment.BankFrom, response.GetStatus(), response.GetMessage())
+	}
+
+	payment.Status = PaymentRefunded
+	payment.Reason = reason
+
+	return s.putHeldPayment(ctx, payment)
+}
@@ -0,0 +1,473 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Settlement modes
+const (
+	SettlementModeImmediate = "immediate"
+	SettlementModeBatch     = "batch"
+)
+
+// Obligation statuses
+const (
+	ObligationPending = "Pending"
+	ObligationSettled = "Settled"
+)
+
+// Obligation is a cross-border payment accepted in batch settlement mode and awaiting SettleBatch.
+// The payer's account has already been debited, but not the sending bank's reserve.
+// DeliveredAmount is what the payee receives in CurrencyTo.
+type Obligation struct {
+	ObligationId    string `json:"obligationId"`
+	BankFrom        string `json:"bankFrom"`
+	BankAccountFrom string `json:"bankAccountFrom"`
+	BankTo          string `json:"bankTo"`
+	BankAccountTo   string `json:"bankAccountTo"`
+	CurrencyFrom    string `json:"currencyFrom"`
+	CurrencyTo      string `json:"currencyTo"`
+	Amount          int    `json:"amount"`
+	DeliveredAmount int    `json:"deliveredAmount"`
+	PaymentType     string `json:"paymentType"`
+	PayerCountry    string `json:"payerCountry"`
+	Status          string `json:"status"`
+	CreatedAt       string `json:"createdAt"`
+	BatchId         string `json:"batchId"`
+	SettledAt       string `json:"settledAt"`
+}
+
+// NetPosition is what the customers of one bank were paid by those of another in a currency,
+// after offsetting payments in both directions
+type NetPosition struct {
+	BankFrom string `json:"bankFrom"`
+	BankTo   string `json:"bankTo"`
+	Currency string `json:"currency"`
+	Amount   int    `json:"amount"`
+}
+
+// BankPosition is the net amount a bank's reserve moves in a currency when a batch settles:
+// negative for what its customers sent and positive for what they received
+type BankPosition struct {
+	Bank     string `json:"bank"`
+	Currency string `json:"currency"`
+	Net      int    `json:"net"`
+}
+
+// Credit is a payment a central bank delivers to a payee's account at a member bank.
+// An empty AccountNo credits only the bank's reserve.
+type Credit struct {
+	Bank         string `json:"bank"`
+	AccountNo    string `json:"accountNo"`
+	Currency     string `json:"currency"`
+	Amount       int    `json:"amount"`
+	PaymentType  string `json:"paymentType"`
+	PayerCountry string `json:"payerCountry"`
+}
+
+// SettlementBatch records the obligations settled together and the net amounts moved between banks
+type SettlementBatch struct {
+	BatchId      string         `json:"batchId"`
+	SettledAt    string         `json:"settledAt"`
+	Obligations  []string       `json:"obligations"`
+	BilateralNet []NetPosition  `json:"bilateralNet"`
+	Multilateral []BankPosition `json:"multilateral"`
+	SettledBy    string         `json:"settledBy"`
+}
+
+// SetSettlementMode chooses whether cross-border payments are forwarded immediately or accumulated for SettleBatch
+func (s *SmartContract) SetSettlementMode(ctx contractapi.TransactionContextInterface, mode string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if mode != SettlementModeImmediate && mode != SettlementModeBatch {
+		return fmt.Errorf("settlement mode must be %s or %s", SettlementModeImmediate, SettlementModeBatch)
+	}
+
+	modeKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"settlementMode"})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(modeKey, []byte(mode))
+}
+
+// GetSettlementMode returns the current settlement mode, immediate unless set otherwise
+func (s *SmartContract) GetSettlementMode(ctx contractapi.TransactionContextInterface) (string, error) {
+	modeKey, err := ctx.GetStub().CreateCompositeKey("config", []string{"settlementMode"})
+	if err != nil {
+		return "", err
+	}
+	mode, err := ctx.GetStub().GetState(modeKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read settlement mode from world state: %v", err)
+	}
+	if mode == nil {
+		return SettlementModeImmediate, nil
+	}
+
+	return string(mode), nil
+}
+
+// recordObligation stores a payment to be settled by the next SettleBatch
+func (s *SmartContract) recordObligation(ctx contractapi.TransactionContextInterface, obligation Obligation) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	obligationKey, err := ctx.GetStub().CreateCompositeKey("obligation", []string{obligation.ObligationId})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(obligationKey)
+	if err != nil {
+		return fmt.Errorf("failed to read obligation from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("obligation %s already exists", obligation.ObligationId)
+	}
+
+	obligation.Status = ObligationPending
+	obligation.CreatedAt = now.Format(time.RFC3339)
+
+	return s.putObligation(ctx, &obligation)
+}
+
+func (s *SmartContract) putObligation(ctx contractapi.TransactionContextInterface, obligation *Obligation) error {
+	obligationKey, err := ctx.GetStub().CreateCompositeKey("obligation", []string{obligation.ObligationId})
+	if err != nil {
+		return err
+	}
+	obligationJSON, err := json.Marshal(obligation)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(obligationKey, obligationJSON)
+}
+
+// GetObligations lists obligations with a status, or every obligation when the status is empty
+func (s *SmartContract) GetObligations(ctx contractapi.TransactionContextInterface, status string) ([]Obligation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("obligation", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	obligations := []Obligation{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var obligation Obligation
+		if err := json.Unmarshal(queryResponse.Value, &obligation); err != nil {
+			return nil, err
+		}
+		if status == "" || obligation.Status == status {
+			obligations = append(obligations, obligation)
+		}
+	}
+
+	return obligations, nil
+}
+
+// netPositions offsets the pending obligations between each pair of banks in each currency
+// and sums what each bank receives into its multilateral position. Results are sorted so every peer computes the same batch.
+func netPositions(obligations []Obligation) ([]NetPosition, []BankPosition) {
+	type pair struct{ from, to, currency string }
+
+	gross := map[pair]int{}
+	banks := map[pair]int{} // keyed by bank and currency only
+	for _, obligation := range obligations {
+		gross[pair{obligation.BankFrom, obligation.BankTo, obligation.CurrencyTo}] += obligation.DeliveredAmount
+		banks[pair{from: obligation.BankFrom, currency: strings.ToUpper(obligation.CurrencyFrom)}] -= obligation.Amount
+		banks[pair{from: obligation.BankTo, currency: obligation.CurrencyTo}] += obligation.DeliveredAmount
+	}
+
+	bilateral := []NetPosition{}
+	for p, amount := range gross {
+		net := amount - gross[pair{p.to, p.from, p.currency}]
+		if net > 0 {
+			bilateral = append(bilateral, NetPosition{BankFrom: p.from, BankTo: p.to, Currency: p.currency, Amount: net})
+		}
+	}
+	sort.Slice(bilateral, func(i, j int) bool {
+		a, b := bilateral[i], bilateral[j]
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		if a.BankFrom != b.BankFrom {
+			return a.BankFrom < b.BankFrom
+		}
+		return a.BankTo < b.BankTo
+	})
+
+	multilateral := []BankPosition{}
+	for p, net := range banks {
+		if net != 0 {
+			multilateral = append(multilateral, BankPosition{Bank: p.from, Currency: p.currency, Net: net})
+		}
+	}
+	sort.Slice(multilateral, func(i, j int) bool {
+		a, b := multilateral[i], multilateral[j]
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		return a.Bank < b.Bank
+	})
+
+	return bilateral, multilateral
+}
+
+// GetNetPositions previews the net positions the next SettleBatch would settle
+func (s *SmartContract) GetNetPositions(ctx contractapi.TransactionContextInterface) (*SettlementBatch, error) {
+	obligations, err := s.GetObligations(ctx, ObligationPending)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := SettlementBatch{Obligations: []string{}}
+	for _, obligation := range obligations {
+		batch.Obligations = append(batch.Obligations, obligation.ObligationId)
+	}
+	batch.BilateralNet, batch.Multilateral = netPositions(obligations)
+
+	return &batch, nil
+}
+
+// SettleBatch settles every pending obligation. Each sending bank's reserve is debited once with its
+// multilateral net position, and the batch fails if a reserve cannot cover it. The obligations are then
+// delivered to each destination central bank in one call, which moves this central bank's nostro and vostro
+// accounts there once and credits each receiving bank's reserve once with its net position before crediting the payees.
+func (s *SmartContract) SettleBatch(ctx contractapi.TransactionContextInterface) (*SettlementBatch, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	obligations, err := s.GetObligations(ctx, ObligationPending)
+	if err != nil {
+		return nil, err
+	}
+	if len(obligations) == 0 {
+		return nil, fmt.Errorf("there are no pending obligations to settle")
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	batch := SettlementBatch{
+		BatchId:     ctx.GetStub().GetTxID(),
+		SettledAt:   now.Format(time.RFC3339),
+		Obligations: []string{},
+		SettledBy:   clientID,
+	}
+	batch.BilateralNet, batch.Multilateral = netPositions(obligations)
+
+	for _, position := range batch.Multilateral {
+		// Positions in other currencies are credited by the destination central banks
+		if position.Currency != centralBankCurrency || position.Net >= 0 {
+			continue
+		}
+		if err := s.debitReserve(ctx, position.Bank, -position.Net); err != nil {
+			return nil, err
+		}
+	}
+
+	credits := map[string][]Credit{} // per destination central bank
+	sourceAmounts := map[string]int{}
+	for i := range obligations {
+		obligation := &obligations[i]
+		centralBnk := strings.ToLower(obligation.CurrencyTo)
+		credits[centralBnk] = append(credits[centralBnk], Credit{
+			Bank:         obligation.BankTo,
+			AccountNo:    obligation.BankAccountTo,
+			Amount:       obligation.DeliveredAmount,
+			PaymentType:  obligation.PaymentType,
+			PayerCountry: obligation.PayerCountry,
+		})
+		sourceAmounts[centralBnk] += obligation.Amount
+
+		obligation.Status = ObligationSettled
+		obligation.BatchId = batch.BatchId
+		obligation.SettledAt = batch.SettledAt
+		if err := s.putObligation(ctx, obligation); err != nil {
+			return nil, err
+		}
+		batch.Obligations = append(batch.Obligations, obligation.ObligationId)
+	}
+
+	centralBnks := make([]string, 0, len(credits))
+	for centralBnk := range credits {
+		centralBnks = append(centralBnks, centralBnk)
+	}
+	sort.Strings(centralBnks)
+
+	for _, centralBnk := range centralBnks {
+		if err := s.deliverCredits(ctx, centralBnk, credits[centralBnk], sourceAmounts[centralBnk]); err != nil {
+			return nil, err
+		}
+	}
+
+	batchKey, err := ctx.GetStub().CreateCompositeKey("batch", []string{batch.BatchId})
+	if err != nil {
+		return nil, err
+	}
+	batchJSON, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(batchKey, batchJSON); err != nil {
+		return nil, err
+	}
+
+	return &batch, ctx.GetStub().SetEvent("BatchSettled", batchJSON)
+}
+
+// deliverCredits pays converted amounts out of this central bank's nostro account at a destination central bank,
+// which credits the payees. The sourceAmount debited from the sending banks' reserves moves to the destination
+// central bank's vostro account, so each currency's supply is conserved. Each account moves once.
+func (s *SmartContract) deliverCredits(ctx contractapi.TransactionContextInterface, centralBnk string, credits []Credit, sourceAmount int) error {
+	total := 0
+	for _, credit := range credits {
+		total += credit.Amount
+	}
+
+	if err := s.adjustCorrespondent(ctx, AccountNostro, centralBnk, -total); err != nil {
+		return err
+	}
+	if err := s.adjustCorrespondent(ctx, AccountVostro, centralBnk, sourceAmount); err != nil {
+		return err
+	}
+
+	creditsJSON, err := json.Marshal(credits)
+	if err != nil {
+		return err
+	}
+
+	fcn := "ReceiveBatch"
+	args := [][]byte{[]byte(fcn), []byte(strings.ToLower(centralBankCurrency)), []byte(fmt.Sprintf("%d", sourceAmount)), creditsJSON}
+
+	response := ctx.GetStub().InvokeChaincode(centralBnk, args, "")
+
+	if response.GetStatus() != 200 {
+		return fmt.Errorf("cbnk chaincode to recieve invoke returned %d. %s", response.GetStatus(), response.GetMessage())
+	}
+
+	return nil
+}
+
+// ReceiveBatch credits incoming cross-border payments from another central bank to the reserves of member banks
+// and to the payees' accounts there. The payments are funded from the vostro account of the sending central bank,
+// which in exchange credits this central bank's nostro account with sourceAmount in its own currency.
+// Only members of the sending central bank's registered MSPs can deliver payments.
+func (s *SmartContract) ReceiveBatch(ctx contractapi.TransactionContextInterface, centralBankFrom string, sourceAmount int, creditsJSON string) error {
+	var credits []Credit
+	if err := json.Unmarshal([]byte(creditsJSON), &credits); err != nil {
+		return fmt.Errorf("failed to parse credits: %v", err)
+	}
+
+	return s.receive(ctx, centralBankFrom, sourceAmount, credits)
+}
+
+// receive moves the correspondent accounts and each member bank's reserve once for all the credits,
+// then has every bank credit its payees in a single call
+func (s *SmartContract) receive(ctx contractapi.TransactionContextInterface, centralBankFrom string, sourceAmount int, credits []Credit) error {
+	if err := s.requireCorrespondent(ctx, centralBankFrom); err != nil {
+		return err
+	}
+	if sourceAmount <= 0 {
+		return fmt.Errorf("source amount must be positive")
+	}
+
+	total := 0
+	reserves := map[string]int{}
+	payees := map[string][]Credit{}
+	for _, credit := range credits {
+		if credit.Amount <= 0 {
+			return fmt.Errorf("credit amount must be positive")
+		}
+		bank := strings.ToLower(credit.Bank)
+		total += credit.Amount
+		reserves[bank] += credit.Amount
+		if credit.AccountNo != "" {
+			credit.Currency = centralBankCurrency
+			payees[bank] = append(payees[bank], credit)
+		}
+	}
+
+	if err := s.adjustCorrespondent(ctx, AccountVostro, centralBankFrom, -total); err != nil {
+		return err
+	}
+	if err := s.adjustCorrespondent(ctx, AccountNostro, centralBankFrom, sourceAmount); err != nil {
+		return err
+	}
+
+	banks := make([]string, 0, len(reserves))
+	for bank := range reserves {
+		banks = append(banks, bank)
+	}
+	sort.Strings(banks)
+
+	for _, bank := range banks {
+		if err := s.creditReserve(ctx, bank, reserves[bank]); err != nil {
+			return err
+		}
+	}
+
+	for _, bank := range banks {
+		if len(payees[bank]) == 0 {
+			continue
+		}
+		creditsJSON, err := json.Marshal(payees[bank])
+		if err != nil {
+			return err
+		}
+
+		args := [][]byte{[]byte("CreditBatch"), creditsJSON}
+
+		response := ctx.GetStub().InvokeChaincode(bank, args, "")
+
+		if response.GetStatus() != 200 {
+			return fmt.Errorf("usd cbnk chaincode receive to add funds invoke returned %d. %s", response.GetStatus(), response.GetMessage())
+		}
+	}
+
+	return nil
+}
+
+// GetSettlementBatch retrieves a settled batch
+func (s *SmartContract) GetSettlementBatch(ctx contractapi.TransactionContextInterface, batchId string) (*SettlementBatch, error) {
+	batchKey, err := ctx.GetStub().CreateCompositeKey("batch", []string{batchId})
+	if err != nil {
+		return nil, err
+	}
+	batchJSON, err := ctx.GetStub().GetState(batchKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settlement batch from world state: %v", err)
+	}
+	if batchJSON == nil {
+		return nil, fmt.Errorf("settlement batch %s does not exist", batchId)
+	}
+
+	var batch SettlementBatch
+	if err := json.Unmarshal(batchJSON, &batch); err != nil {
+		return nil, err
+	}
+
+	return &batch, nil
+}
@@ -0,0 +1,188 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestSettleBatchMovesEachAccountOnceForACorridor(t *testing.T) {
+	s := &SmartContract{}
+	l := newLedger(t)
+	foreign := otherCentralBank()
+	destination := l.fake(foreign)
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		if err := s.InitLedger(ctx); err != nil {
+			return err
+		}
+		return s.adjustCorrespondent(ctx, AccountNostro, foreign, 1000)
+	})
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		for _, bank := range []string{"adfc", "yesbi"} {
+			if err := s.RegisterMemberBank(ctx, bank, 1000); err != nil {
+				return err
+			}
+		}
+		for _, obligation := range []Obligation{
+			{ObligationId: "pay1-0", BankFrom: "adfc", BankAccountFrom: "a1", BankTo: "ibibi", BankAccountTo: "b1", CurrencyFrom: centralBankCurrency, CurrencyTo: strings.ToUpper(foreign), Amount: 100, DeliveredAmount: 40, PaymentType: "wages"},
+			{ObligationId: "pay2-0", BankFrom: "yesbi", BankAccountFrom: "c1", BankTo: "ibibi", BankAccountTo: "b2", CurrencyFrom: centralBankCurrency, CurrencyTo: strings.ToUpper(foreign), Amount: 50, DeliveredAmount: 20, PaymentType: "transfer"},
+		} {
+			if err := s.recordObligation(ctx, obligation); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	var batch *SettlementBatch
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		batch, err = s.SettleBatch(ctx)
+		return err
+	})
+
+	if len(batch.Obligations) != 2 {
+		t.Fatalf("settled %d obligations, want 2", len(batch.Obligations))
+	}
+	positions := map[string]int{}
+	for _, position := range batch.Multilateral {
+		positions[position.Bank+" "+position.Currency] = position.Net
+	}
+	want := map[string]int{
+		"adfc " + centralBankCurrency:       -100,
+		"yesbi " + centralBankCurrency:      -50,
+		"ibibi " + strings.ToUpper(foreign): 60,
+	}
+	if len(positions) != len(want) {
+		t.Fatalf("multilateral positions %+v, want %v", batch.Multilateral, want)
+	}
+	for key, net := range want {
+		if positions[key] != net {
+			t.Fatalf("multilateral positions %+v, want %v", batch.Multilateral, want)
+		}
+	}
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		nostro, err := s.getCorrespondent(ctx, AccountNostro, foreign)
+		if err != nil {
+			return err
+		}
+		if nostro.Balance != 1000-60 {
+			t.Errorf("nostro balance %d, want %d", nostro.Balance, 1000-60)
+		}
+		vostro, err := s.getCorrespondent(ctx, AccountVostro, foreign)
+		if err != nil {
+			return err
+		}
+		if vostro.Balance != 150 {
+			t.Errorf("vostro balance %d, want 150", vostro.Balance)
+		}
+		for bank, balance := range map[string]int{"adfc": 1000 - 100, "yesbi": 1000 - 50} {
+			reserve, err := s.GetReserve(ctx, bank)
+			if err != nil {
+				return err
+			}
+			if reserve.Balance != balance {
+				t.Errorf("%s reserve balance %d, want %d", bank, reserve.Balance, balance)
+			}
+		}
+		pending, err := s.GetObligations(ctx, ObligationPending)
+		if err != nil {
+			return err
+		}
+		if len(pending) != 0 {
+			t.Errorf("%d obligations still pending", len(pending))
+		}
+		return nil
+	})
+
+	if len(destination.calls) != 1 {
+		t.Fatalf("destination central bank called %d times, want once", len(destination.calls))
+	}
+	call := destination.calls[0]
+	if call[0] != "ReceiveBatch" || call[1] != strings.ToLower(centralBankCurrency) || call[2] != "150" {
+		t.Fatalf("destination central bank called with %v", call[:3])
+	}
+	var credits []Credit
+	if err := json.Unmarshal([]byte(call[3]), &credits); err != nil {
+		t.Fatal(err)
+	}
+	if len(credits) != 2 || credits[0].Amount+credits[1].Amount != 60 {
+		t.Fatalf("delivered credits %+v, want both obligations", credits)
+	}
+}
+
+func TestReceiveBatchCreditsEachReserveOnce(t *testing.T) {
+	s := &SmartContract{}
+	l := newLedger(t)
+	foreign := otherCentralBank()
+	bank := l.fake("ibibi")
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.InitLedger(ctx)
+	})
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		if err := s.RegisterMemberBank(ctx, "ibibi", 10); err != nil {
+			return err
+		}
+		return s.adjustCorrespondent(ctx, AccountVostro, foreign, 500)
+	})
+
+	credits, err := json.Marshal([]Credit{
+		{Bank: "ibibi", AccountNo: "b1", Amount: 40, PaymentType: "wages"},
+		{Bank: "ibibi", AccountNo: "b1", Amount: 20, PaymentType: "transfer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	correspondent := testIdentity{id: foreign + "-admin", mspID: strings.ToUpper(foreign) + "MSP"}
+	receiveBatch := func(ctx contractapi.TransactionContextInterface) error {
+		return s.ReceiveBatch(ctx, foreign, 150, string(credits))
+	}
+	if err := l.submit(t, correspondent, receiveBatch); err == nil {
+		t.Fatalf("batch delivered by an unregistered MSP was accepted")
+	}
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return s.RegisterCorrespondentMSP(ctx, foreign, correspondent.mspID)
+	})
+	l.mustSubmit(t, correspondent, receiveBatch)
+
+	l.mustSubmit(t, admin, func(ctx contractapi.TransactionContextInterface) error {
+		reserve, err := s.GetReserve(ctx, "ibibi")
+		if err != nil {
+			return err
+		}
+		if reserve.Balance != 70 {
+			t.Errorf("reserve balance %d, want 70", reserve.Balance)
+		}
+		vostro, err := s.getCorrespondent(ctx, AccountVostro, foreign)
+		if err != nil {
+			return err
+		}
+		if vostro.Balance != 440 {
+			t.Errorf("vostro balance %d, want 440", vostro.Balance)
+		}
+		nostro, err := s.getCorrespondent(ctx, AccountNostro, foreign)
+		if err != nil {
+			return err
+		}
+		if nostro.Balance != 150 {
+			t.Errorf("nostro balance %d, want 150", nostro.Balance)
+		}
+		return nil
+	})
+
+	if len(bank.calls) != 1 || bank.calls[0][0] != "CreditBatch" {
+		t.Fatalf("bank called with %v, want a single CreditBatch", bank.calls)
+	}
+	var delivered []Credit
+	if err := json.Unmarshal([]byte(bank.calls[0][1]), &delivered); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 2 || delivered[0].Currency != centralBankCurrency {
+		t.Fatalf("bank credited %+v, want both credits in %s", delivered, centralBankCurrency)
+	}
+}
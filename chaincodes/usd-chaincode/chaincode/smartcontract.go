@@ -1,9 +1,11 @@
 package chaincode
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -13,22 +15,108 @@ type SmartContract struct {
     contractapi.Contract
 }
 
+// Currency issued by this central bank
+const centralBankCurrency = "USD"
 
+// internationalTransferFeePercent is charged on payments forwarded to another central bank when no fee schedule has been set
+const internationalTransferFeePercent = 2.0
 
+// Roles that may be assigned to client identities
+const (
+    RoleAdmin      = "admin"
+    RoleCompliance = "compliance"
+)
 
 // InitLedger initializes the ledger with sample assets
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-    // Initialize any necessary data here
-    
+    // The first identity to initialize the ledger becomes the central bank admin
+    adminID, err := s.getRole(ctx, RoleAdmin)
+    if err != nil {
+        return err
+    }
+    if adminID != "" {
+        return nil
+    }
+
+    clientID, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return fmt.Errorf("failed to get client identity: %v", err)
+    }
+
+    return s.putRole(ctx, RoleAdmin, clientID)
+}
+
+// AssignRole assigns a role such as compliance to a client identity. Only the admin may assign roles.
+func (s *SmartContract) AssignRole(ctx contractapi.TransactionContextInterface, role string, clientID string) error {
+    if err := s.requireRole(ctx, RoleAdmin); err != nil {
+        return err
+    }
+    if role != RoleAdmin && role != RoleCompliance {
+        return fmt.Errorf("unknown role %s", role)
+    }
+
+    return s.putRole(ctx, role, clientID)
+}
+
+func (s *SmartContract) getRole(ctx contractapi.TransactionContextInterface, role string) (string, error) {
+    roleKey, err := ctx.GetStub().CreateCompositeKey("role", []string{role})
+    if err != nil {
+        return "", err
+    }
+    clientID, err := ctx.GetStub().GetState(roleKey)
+    if err != nil {
+        return "", fmt.Errorf("failed to read %s role from world state: %v", role, err)
+    }
+
+    return string(clientID), nil
+}
+
+func (s *SmartContract) putRole(ctx contractapi.TransactionContextInterface, role string, clientID string) error {
+    roleKey, err := ctx.GetStub().CreateCompositeKey("role", []string{role})
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(roleKey, []byte(clientID))
+}
+
+// requireRole returns an error unless the caller holds the role
+func (s *SmartContract) requireRole(ctx contractapi.TransactionContextInterface, role string) error {
+    roleID, err := s.getRole(ctx, role)
+    if err != nil {
+        return err
+    }
+    if roleID == "" {
+        return fmt.Errorf("no identity holds the %s role", role)
+    }
+
+    clientID, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return fmt.Errorf("failed to get client identity: %v", err)
+    }
+    if clientID != roleID {
+        return fmt.Errorf("caller does not hold the %s role", role)
+    }
+
     return nil
 }
 
+// txTime returns the transaction timestamp, which is identical on every endorsing peer
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+    timestamp, err := ctx.GetStub().GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+    }
+
+    return timestamp.AsTime().UTC(), nil
+}
 
-func (s *SmartContract) InvokeForex(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int) (int, error) {
+// InvokeForex converts an amount at the current rate and the forex fee schedule of the member bank bankFrom
+func (s *SmartContract) InvokeForex(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bankFrom string) (int, error) {
     
     fcn := "Forex"
 
-    args := [][]byte{[]byte(fcn), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount))}
+    args := [][]byte{[]byte(fcn), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount)), []byte(strings.ToLower(bankFrom))}
 
     response := ctx.GetStub().InvokeChaincode("forex", args, "")
 
@@ -44,43 +132,273 @@ func (s *SmartContract) InvokeForex(ctx contractapi.TransactionContextInterface,
     return payload, nil
 }
 
-func (s *SmartContract) Receive(ctx contractapi.TransactionContextInterface, bank string, bankAccount string, amount int) error {
-    
-    fcn := "AddFunds"
-    bankName := strings.ToLower(bank)
+// InvokeForexAtQuote converts an amount at the rate locked by a forex quote and marks the quote used.
+// The quote must have been requested by the organization submitting the payment.
+func (s *SmartContract) InvokeForexAtQuote(ctx contractapi.TransactionContextInterface, quoteId string, currencyFrom string, currencyTo string, amount int) (int, error) {
+
+    fcn := "ExecuteQuote"
 
-    args := [][]byte{[]byte(fcn), []byte(bankAccount), []byte(fmt.Sprintf("%d", amount))}
+    args := [][]byte{[]byte(fcn), []byte(quoteId), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount))}
 
-    response := ctx.GetStub().InvokeChaincode(bankName, args, "")
+    response := ctx.GetStub().InvokeChaincode("forex", args, "")
 
     if response.GetStatus() != 200 {
-        return fmt.Errorf("usd cbnk chaincode receive to add funds invoke returned %d. %s", response.GetStatus(), response.GetMessage())
+        return 0, fmt.Errorf("forex chaincode quote execution returned %d. %s", response.GetStatus(), response.GetMessage())
     }
 
-    return nil
-    
+    payload, err := strconv.Atoi(string(response.GetPayload()))
+    if err != nil {
+        return 0, err
+    }
+
+    return payload, nil
 }
 
-func (s *SmartContract) PayCentralBnk(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string, bankAccount string) error {
-    
-    toSend, err := s.InvokeForex(ctx, currencyFrom, currencyTo, amount)
+// Conversion itemizes a forex conversion as returned by the forex chaincode
+type Conversion struct {
+    CurrencyFrom    string  `json:"currencyFrom"`
+    CurrencyTo      string  `json:"currencyTo"`
+    Amount          int     `json:"amount"`
+    Bank            string  `json:"bank"`
+    Rate            float64 `json:"rate"`
+    GrossAmount     int     `json:"grossAmount"`
+    FeeSchedule     string  `json:"feeSchedule"`
+    FeePercent      float64 `json:"feePercent"`
+    Fees            int     `json:"fees"`
+    ConvertedAmount int     `json:"convertedAmount"`
+}
+
+// TransferPreview itemizes what a payment forwarded by PayCentralBnk delivers to the destination bank
+type TransferPreview struct {
+    Conversion              Conversion `json:"conversion"`
+    FeeSchedule             string     `json:"feeSchedule"`
+    InternationalFeePercent float64    `json:"internationalFeePercent"` // effective percentage of the converted amount
+    InternationalFee        int        `json:"internationalFee"` // in the destination currency
+    DeliveredAmount         int        `json:"deliveredAmount"`
+}
+
+// PreviewPayCentralBnk returns the deductions PayCentralBnk would apply to a payment from a member bank
+// without moving any funds, at the current rate or at the rate locked by a non-empty quoteId
+func (s *SmartContract) PreviewPayCentralBnk(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bankFrom string, quoteId string) (*TransferPreview, error) {
+
+    fcn := "PreviewForex"
+
+    args := [][]byte{[]byte(fcn), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount)), []byte(strings.ToLower(bankFrom))}
+    if quoteId != "" {
+        fcn = "PreviewQuote"
+        args = [][]byte{[]byte(fcn), []byte(quoteId), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount))}
+    }
+
+    response := ctx.GetStub().InvokeChaincode("forex", args, "")
+
+    if response.GetStatus() != 200 {
+        return nil, fmt.Errorf("forex chaincode preview returned %d. %s", response.GetStatus(), response.GetMessage())
+    }
+
+    var conversion Conversion
+    if err := json.Unmarshal(response.GetPayload(), &conversion); err != nil {
+        return nil, err
+    }
+
+    fee, schedule, err := s.internationalFee(ctx, currencyFrom, currencyTo, bankFrom, conversion.ConvertedAmount)
+    if err != nil {
+        return nil, err
+    }
+
+    feePercent := 0.0
+    if conversion.ConvertedAmount > 0 {
+        feePercent = float64(fee) * 100 / float64(conversion.ConvertedAmount)
+    }
+
+    return &TransferPreview{
+        Conversion:              conversion,
+        FeeSchedule:             schedule.Corridor + "/" + schedule.Bank + "/" + schedule.EffectiveFrom,
+        InternationalFeePercent: feePercent,
+        InternationalFee:        fee,
+        DeliveredAmount:         conversion.ConvertedAmount - fee,
+    }, nil
+}
+
+// Receive credits an incoming cross-border payment to the reserve of a member bank and to the payee's account there.
+// An empty bankAccount credits only the reserve, for conversions the bank books itself.
+// The payment is funded from the vostro account of the sending central bank, which in exchange
+// credits this central bank's nostro account with sourceAmount in its own currency.
+// Only members of the sending central bank's registered MSPs can deliver payments.
+func (s *SmartContract) Receive(ctx contractapi.TransactionContextInterface, bank string, bankAccount string, amount int, paymentType string, payerCountry string, centralBankFrom string, sourceAmount int) error {
+    credit := Credit{
+        Bank:         bank,
+        AccountNo:    bankAccount,
+        Amount:       amount,
+        PaymentType:  paymentType,
+        PayerCountry: payerCountry,
+    }
+
+    return s.receive(ctx, centralBankFrom, sourceAmount, []Credit{credit})
+}
+
+// ConvertReserves converts part of a member bank's reserve into another currency at the forex rate and
+// returns the amount credited to the bank's reserve at the central bank of that currency. Banks call it to back
+// the currency conversions of their customers, which they book on the accounts themselves.
+// Conversions move no money between banks, so they bypass screening and settle immediately in either settlement mode.
+// Only members of the bank's registered MSPs can convert its reserve.
+func (s *SmartContract) ConvertReserves(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string) (int, error) {
+
+    if !strings.EqualFold(currencyFrom, centralBankCurrency) {
+        return 0, fmt.Errorf("%s central bank cannot convert %s", centralBankCurrency, currencyFrom)
+    }
+    if strings.EqualFold(currencyFrom, currencyTo) {
+        return 0, fmt.Errorf("cannot convert %s to itself", currencyFrom)
+    }
+    if amount <= 0 {
+        return 0, fmt.Errorf("amount to convert must be positive")
+    }
+    if err := s.requireMemberBank(ctx, bank); err != nil {
+        return 0, err
+    }
+
+    if err := s.debitReserve(ctx, bank, amount); err != nil {
+        return 0, err
+    }
+
+    converted, err := s.InvokeForex(ctx, currencyFrom, currencyTo, amount, bank)
+    if err != nil {
+        return 0, err
+    }
+
+    if err := s.deliverPayment(ctx, currencyTo, bank, "", converted, amount, "", ""); err != nil {
+        return 0, err
+    }
+
+    return converted, nil
+}
+
+// PayCentralBnk converts a payment into the destination currency and forwards it to the destination central bank.
+// Payments the sending bank's reserve cannot cover are rejected. The reserve is debited when the payment is
+// forwarded, or by SettleBatch in batch settlement mode.
+// Payments matching the compliance watchlist are held for review instead.
+// A non-empty quoteId converts at the rate locked by that forex quote. Held payments use the quote when released.
+// The paymentId assigned by the sending bank identifies the payment while it is held or awaits settlement.
+// Only members of the sending bank's registered MSPs can submit its payments.
+func (s *SmartContract) PayCentralBnk(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bank string, bankAccount string, bankFrom string, bankAccountFrom string, payerName string, paymentType string, payerCountry string, quoteId string, paymentId string) error {
+
+    if !strings.EqualFold(currencyFrom, centralBankCurrency) {
+        return fmt.Errorf("%s central bank cannot send %s payments", centralBankCurrency, currencyFrom)
+    }
+    if amount <= 0 {
+        return fmt.Errorf("amount must be positive")
+    }
+    if paymentId == "" {
+        return fmt.Errorf("payment id is required")
+    }
+    if err := s.requireMemberBank(ctx, bankFrom); err != nil {
+        return err
+    }
+
+    if err := s.checkReserve(ctx, bankFrom, amount); err != nil {
+        return err
+    }
+
+    payeeName, err := s.getAccountOwner(ctx, bank, bankAccount)
+    if err != nil {
+        return err
+    }
+
+    matches, err := s.screenPayment(ctx,
+        []string{payerName, payeeName},
+        []string{bankAccountFrom, bankAccount},
+        []string{payerCountry, countryOf(currencyFrom), countryOf(currencyTo)},
+    )
     if err != nil {
         return err
     }
 
-    internationalTransferFees := 0.02 // 2%
-    feesMultiplier := 1.0 - internationalTransferFees
+    if len(matches) > 0 {
+        return s.holdPayment(ctx, HeldPayment{
+            PaymentId:       paymentId,
+            CurrencyFrom:    currencyFrom,
+            CurrencyTo:      currencyTo,
+            Amount:          amount,
+            Bank:            bank,
+            BankAccount:     bankAccount,
+            BankFrom:        bankFrom,
+            BankAccountFrom: bankAccountFrom,
+            PayerName:       payerName,
+            PayeeName:       payeeName,
+            PaymentType:     paymentType,
+            PayerCountry:    payerCountry,
+            QuoteId:         quoteId,
+            Matches:         matches,
+        })
+    }
 
-    fcn := "Receive"
-    centralBnk := strings.ToLower(currencyTo)
-    args := [][]byte{[]byte(fcn), []byte(bank), []byte(bankAccount), []byte(fmt.Sprintf("%d", int(feesMultiplier * float64(toSend))))}
+    return s.forwardPayment(ctx, paymentId, currencyFrom, currencyTo, amount, quoteId, bank, bankAccount, bankFrom, bankAccountFrom, paymentType, payerCountry)
+}
 
-    response := ctx.GetStub().InvokeChaincode(centralBnk, args, "")
+// forwardPayment debits the sending bank's reserve, converts the amount, charges the sending bank's
+// international transfer fee and sends the rest to the destination central bank.
+// In batch settlement mode the payment is recorded as an obligation for the next SettleBatch instead,
+// which debits the reserve by the bank's net position.
+// Quoted payments convert at the quoted rate and others at the current rate.
+func (s *SmartContract) forwardPayment(ctx contractapi.TransactionContextInterface, paymentId string, currencyFrom string, currencyTo string, amount int, quoteId string, bank string, bankAccount string, bankFrom string, bankAccountFrom string, paymentType string, payerCountry string) error {
 
-    if response.GetStatus() != 200 {
-        return fmt.Errorf("cbnk chaincode to recieve invoke returned %d. %s", response.GetStatus(), response.GetMessage())
+    mode, err := s.GetSettlementMode(ctx)
+    if err != nil {
+        return err
+    }
+    if mode == SettlementModeBatch {
+        err = s.checkReserve(ctx, bankFrom, amount)
+    } else {
+        err = s.debitReserve(ctx, bankFrom, amount)
+    }
+    if err != nil {
+        return err
     }
 
-    return nil
+    var toSend int
+    if quoteId != "" {
+        toSend, err = s.InvokeForexAtQuote(ctx, quoteId, currencyFrom, currencyTo, amount)
+    } else {
+        toSend, err = s.InvokeForex(ctx, currencyFrom, currencyTo, amount, bankFrom)
+    }
+    if err != nil {
+        return err
+    }
 
-}
\ No newline at end of file
+    fee, _, err := s.internationalFee(ctx, currencyFrom, currencyTo, bankFrom, toSend)
+    if err != nil {
+        return err
+    }
+
+    if mode == SettlementModeBatch {
+        return s.recordObligation(ctx, Obligation{
+            ObligationId:    paymentId,
+            BankFrom:        strings.ToLower(bankFrom),
+            BankAccountFrom: bankAccountFrom,
+            BankTo:          strings.ToLower(bank),
+            BankAccountTo:   bankAccount,
+            CurrencyFrom:    currencyFrom,
+            CurrencyTo:      currencyTo,
+            Amount:          amount,
+            DeliveredAmount: toSend - fee,
+            PaymentType:     paymentType,
+            PayerCountry:    payerCountry,
+        })
+    }
+
+    return s.deliverPayment(ctx, currencyTo, bank, bankAccount, toSend - fee, amount, paymentType, payerCountry)
+}
+
+// deliverPayment pays a converted amount out of this central bank's nostro account at the destination
+// central bank, which credits the payee. The sourceAmount debited from the sending bank's reserve
+// moves to the destination central bank's vostro account, so each currency's supply is conserved.
+func (s *SmartContract) deliverPayment(ctx contractapi.TransactionContextInterface, currencyTo string, bank string, bankAccount string, amount int, sourceAmount int, paymentType string, payerCountry string) error {
+    credit := Credit{
+        Bank:         bank,
+        AccountNo:    bankAccount,
+        Amount:       amount,
+        PaymentType:  paymentType,
+        PayerCountry: payerCountry,
+    }
+
+    return s.deliverCredits(ctx, strings.ToLower(currencyTo), []Credit{credit}, sourceAmount)
+}
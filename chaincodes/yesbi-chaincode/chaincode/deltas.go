@@ -0,0 +1,139 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SetHighThroughput switches an account in or out of high-throughput mode, in which its balance is not
+// rewritten on every credit. Each credit is then written as a delta under its own key,
+// balancedelta~accountNo~currency~txId~index, so concurrent credits to the account touch different keys.
+// CompactBalance periodically folds the deltas into the stored balance. Debits are checked against the
+// stored balance alone, which never exceeds the true balance.
+// Leaving the mode compacts any pending credits first.
+// Only the bank admin may change the mode of an account.
+func (s *SmartContract) SetHighThroughput(ctx contractapi.TransactionContextInterface, accountNo string, enabled bool) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if _, err := s.compactDeltas(ctx, bankAccountAsset); err != nil {
+			return err
+		}
+	}
+	bankAccountAsset.HighThroughput = enabled
+
+	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+}
+
+// CompactBalance folds the pending credits of an account into its stored balance
+// and returns the number of credits folded
+func (s *SmartContract) CompactBalance(ctx contractapi.TransactionContextInterface, accountNo string) (int, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return 0, err
+	}
+
+	compacted, err := s.compactDeltas(ctx, bankAccountAsset)
+	if err != nil {
+		return 0, err
+	}
+	if compacted == 0 {
+		return 0, nil
+	}
+
+	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
+	if err != nil {
+		return 0, err
+	}
+
+	return compacted, ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+}
+
+// GetPendingCredits returns the credits per currency not yet compacted into an account's balance
+func (s *SmartContract) GetPendingCredits(ctx contractapi.TransactionContextInterface, accountNo string) (map[string]int, error) {
+	pending := map[string]int{}
+	err := s.eachDelta(ctx, []string{accountNo}, func(key string, currency string, amount int) error {
+		pending[currency] += amount
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// putBalanceDelta records a credit to an account in high-throughput mode. The index numbers the credits
+// of one call, so several credits to the account in a transaction each keep their own key.
+func (s *SmartContract) putBalanceDelta(ctx contractapi.TransactionContextInterface, bankAccountAsset *BankAccountAsset, currency string, amount int, index int) error {
+	if currency == "" {
+		currency = homeCurrency(bankAccountAsset)
+	}
+	deltaKey, err := ctx.GetStub().CreateCompositeKey("balancedelta", []string{bankAccountAsset.AccountNo, strings.ToUpper(currency), ctx.GetStub().GetTxID(), strconv.Itoa(index)})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(deltaKey, []byte(strconv.Itoa(amount)))
+}
+
+// compactDeltas adds the pending credits of an account to its balances and deletes them.
+// The caller writes the account back.
+func (s *SmartContract) compactDeltas(ctx contractapi.TransactionContextInterface, bankAccountAsset *BankAccountAsset) (int, error) {
+	compacted := 0
+	err := s.eachDelta(ctx, []string{bankAccountAsset.AccountNo}, func(key string, currency string, amount int) error {
+		adjustBalance(bankAccountAsset, currency, amount)
+		compacted++
+		return ctx.GetStub().DelState(key)
+	})
+
+	return compacted, err
+}
+
+// eachDelta calls fn with every pending credit whose key starts with the given attributes
+func (s *SmartContract) eachDelta(ctx contractapi.TransactionContextInterface, attributes []string, fn func(key string, currency string, amount int) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("balancedelta", attributes)
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		amount, err := strconv.Atoi(string(queryResponse.Value))
+		if err != nil {
+			return fmt.Errorf("failed to convert balance delta to int: %v", err)
+		}
+		if err := fn(queryResponse.Key, keyParts[1], amount); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Deduction is a single fee or tax taken from a payment
+type Deduction struct {
+	Description string  `json:"description"`
+	Currency    string  `json:"currency"`
+	RatePercent float64 `json:"ratePercent"`
+	Amount      int     `json:"amount"`
+}
+
+// PaymentPreview itemizes what a payment would credit to the payee
+type PaymentPreview struct {
+	CurrencyFrom   string      `json:"currencyFrom"`
+	CurrencyTo     string      `json:"currencyTo"`
+	Amount         int         `json:"amount"`
+	ForexRate      float64     `json:"forexRate"`
+	GrossAmount    int         `json:"grossAmount"` // in currencyTo, before any deduction
+	Deductions     []Deduction `json:"deductions"`
+	CreditedAmount int         `json:"creditedAmount"`
+}
+
+// CreditPreview is the withholding tax a credit to an account would incur
+type CreditPreview struct {
+	AccountNo      string  `json:"accountNo"`
+	Currency       string  `json:"currency"`
+	Amount         int     `json:"amount"`
+	RatePercent    float64 `json:"ratePercent"`
+	Withheld       int     `json:"withheld"`
+	CreditedAmount int     `json:"creditedAmount"`
+}
+
+// conversion is the forex breakdown returned inside a central bank transfer preview
+type conversion struct {
+	Rate        float64 `json:"rate"`
+	GrossAmount int     `json:"grossAmount"`
+	FeePercent  float64 `json:"feePercent"`
+	Fees        int     `json:"fees"`
+}
+
+// transferPreview is the breakdown returned by the central bank's PreviewPayCentralBnk
+type transferPreview struct {
+	Conversion              conversion `json:"conversion"`
+	InternationalFeePercent float64    `json:"internationalFeePercent"`
+	InternationalFee        int        `json:"internationalFee"`
+	DeliveredAmount         int        `json:"deliveredAmount"`
+}
+
+// PreviewCredit returns the withholding tax CreditFunds would apply without moving any funds.
+// An account that does not exist yet is previewed as a new account.
+func (s *SmartContract) PreviewCredit(ctx contractapi.TransactionContextInterface, accountNo string, currency string, amount int, paymentType string, payerCountry string) (*CreditPreview, error) {
+	return s.previewCredit(ctx, accountNo, currency, amount, paymentType, payerCountry, false)
+}
+
+func (s *SmartContract) previewCredit(ctx contractapi.TransactionContextInterface, accountNo string, currency string, amount int, paymentType string, payerCountry string, exempt bool) (*CreditPreview, error) {
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		bankAccountAsset = &BankAccountAsset{
+			AccountNo: accountNo,
+			Balances:  map[string]int{},
+			Status:    StatusActive,
+		}
+	}
+
+	if err := checkCanMoveFunds(bankAccountAsset, amount); err != nil {
+		return nil, err
+	}
+
+	if payerCountry == "" {
+		payerCountry = residencyOf(bankAccountAsset)
+	}
+
+	ratePercent := 0.0
+	if !exempt {
+		ratePercent, err = s.withholdingRate(ctx, bankAccountAsset, paymentType, payerCountry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	withheld := int(float64(amount) * ratePercent / 100)
+
+	return &CreditPreview{
+		AccountNo:      accountNo,
+		Currency:       strings.ToUpper(currency),
+		Amount:         amount,
+		RatePercent:    ratePercent,
+		Withheld:       withheld,
+		CreditedAmount: amount - withheld,
+	}, nil
+}
+
+// PreviewPayment returns an itemized breakdown of the fees and tax a payment would incur and the amount
+// the payee would be credited, at current rates and without moving any funds.
+// It follows the same route as Pay, querying the central bank, forex and destination bank chaincodes.
+// Tax is previewed for the residency of the payer's account. A non-empty quoteId previews the rate locked
+// by that forex quote, which must be usable by the payment.
+func (s *SmartContract) PreviewPayment(ctx contractapi.TransactionContextInterface, currencyFrom string, currencyTo string, amount int, bankAccountFrom string, bankTo string, bankAccountTo string, paymentType string, quoteId string) (*PaymentPreview, error) {
+
+	if paymentType != PaymentWages && paymentType != PaymentTransfer {
+		return nil, fmt.Errorf("payment type must be %s or %s", PaymentWages, PaymentTransfer)
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	if quoteId != "" && currencyFrom == currencyTo {
+		return nil, fmt.Errorf("forex quotes only apply to payments between currencies")
+	}
+
+	payer, err := s.readBankAccountAsset(ctx, bankAccountFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := PaymentPreview{
+		CurrencyFrom: currencyFrom,
+		CurrencyTo:   currencyTo,
+		Amount:       amount,
+		ForexRate:    1,
+		GrossAmount:  amount,
+		Deductions:   []Deduction{},
+	}
+	delivered := amount
+
+	if currencyFrom != currencyTo {
+		args := [][]byte{[]byte("PreviewPayCentralBnk"), []byte(currencyFrom), []byte(currencyTo), []byte(fmt.Sprintf("%d", amount)), []byte("yesbi"), []byte(quoteId)}
+
+		response := ctx.GetStub().InvokeChaincode(strings.ToLower(currencyFrom), args, "")
+
+		if response.GetStatus() != 200 {
+			return nil, fmt.Errorf("yesbi to central bank chaincode preview returned %d. %s", response.GetStatus(), response.GetMessage())
+		}
+
+		var transfer transferPreview
+		if err := json.Unmarshal(response.GetPayload(), &transfer); err != nil {
+			return nil, err
+		}
+
+		preview.ForexRate = transfer.Conversion.Rate
+		preview.GrossAmount = transfer.Conversion.GrossAmount
+		preview.Deductions = append(preview.Deductions,
+			Deduction{
+				Description: "forex fee",
+				Currency:    currencyTo,
+				RatePercent: transfer.Conversion.FeePercent,
+				Amount:      transfer.Conversion.Fees,
+			},
+			Deduction{
+				Description: "international transfer fee",
+				Currency:    currencyTo,
+				RatePercent: transfer.InternationalFeePercent,
+				Amount:      transfer.InternationalFee,
+			},
+		)
+		delivered = transfer.DeliveredAmount
+	}
+
+	payerCountry := residencyOf(payer)
+
+	var credit *CreditPreview
+	if bankTo == "yesbi" {
+		// Transfers between accounts of the same bank are exempt from withholding
+		credit, err = s.previewCredit(ctx, bankAccountTo, currencyTo, delivered, paymentType, payerCountry, currencyFrom == currencyTo && paymentType == PaymentTransfer)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		args := [][]byte{[]byte("PreviewCredit"), []byte(bankAccountTo), []byte(currencyTo), []byte(fmt.Sprintf("%d", delivered)), []byte(paymentType), []byte(payerCountry)}
+
+		response := ctx.GetStub().InvokeChaincode(strings.ToLower(bankTo), args, "")
+
+		if response.GetStatus() != 200 {
+			return nil, fmt.Errorf("%s chaincode credit preview returned %d. %s", bankTo, response.GetStatus(), response.GetMessage())
+		}
+
+		credit = &CreditPreview{}
+		if err := json.Unmarshal(response.GetPayload(), credit); err != nil {
+			return nil, err
+		}
+	}
+
+	if credit.Withheld > 0 {
+		preview.Deductions = append(preview.Deductions, Deduction{
+			Description: "withholding tax",
+			Currency:    currencyTo,
+			RatePercent: credit.RatePercent,
+			Amount:      credit.Withheld,
+		})
+	}
+	preview.CreditedAmount = credit.CreditedAmount
+
+	return &preview, nil
+}
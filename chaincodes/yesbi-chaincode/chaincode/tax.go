@@ -0,0 +1,421 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Payment types used to select withholding tax rules
+const (
+	PaymentWages    = "wages"
+	PaymentTransfer = "transfer"
+	PaymentRefund   = "refund"
+)
+
+// taxAuthority owns the tax withholding accounts
+const taxAuthority = "tax authority"
+
+// currencyCountries maps each currency to the country of its central bank
+var currencyCountries = map[string]string{
+	"USD": "US",
+	"INR": "IN",
+}
+
+func countryOf(currency string) string {
+	return currencyCountries[strings.ToUpper(currency)]
+}
+
+// fiscalYearStartMonths lists countries whose fiscal year does not start in January
+var fiscalYearStartMonths = map[string]time.Month{
+	"IN": time.April,
+}
+
+// TaxRule is the withholding rate for a payment type between a payer and a payee country.
+// A payer country of "*" applies to payers from any country without a treaty rule.
+type TaxRule struct {
+	PaymentType  string  `json:"paymentType"`
+	PayerCountry string  `json:"payerCountry"`
+	PayeeCountry string  `json:"payeeCountry"`
+	RatePercent  float64 `json:"ratePercent"`
+}
+
+// TaxEntry records the tax withheld from a single credit
+type TaxEntry struct {
+	TxId         string  `json:"txId"`
+	Currency     string  `json:"currency"`
+	PaymentType  string  `json:"paymentType"`
+	PayerCountry string  `json:"payerCountry"`
+	Gross        int     `json:"gross"`
+	RatePercent  float64 `json:"ratePercent"`
+	Withheld     int     `json:"withheld"`
+	Date         string  `json:"date"`
+}
+
+// TaxCertificate summarizes the tax withheld from an account in a fiscal year
+type TaxCertificate struct {
+	AccountNo   string         `json:"accountNo"`
+	Owner       string         `json:"owner"`
+	Residency   string         `json:"residency"`
+	FiscalYear  int            `json:"fiscalYear"`
+	PeriodStart string         `json:"periodStart"`
+	PeriodEnd   string         `json:"periodEnd"`
+	Gross       map[string]int `json:"gross"`    // per currency
+	Withheld    map[string]int `json:"withheld"` // per currency
+	Entries     []TaxEntry     `json:"entries"`
+}
+
+// fiscalYearOf returns the calendar year in which the fiscal year containing t starts
+func fiscalYearOf(residency string, t time.Time) int {
+	startMonth, ok := fiscalYearStartMonths[residency]
+	if !ok {
+		startMonth = time.January
+	}
+	if t.Month() < startMonth {
+		return t.Year() - 1
+	}
+	return t.Year()
+}
+
+// residencyOf returns the tax residency of an account, defaulting to the country of its currency
+func residencyOf(bankAccountAsset *BankAccountAsset) string {
+	if bankAccountAsset.Residency != "" {
+		return bankAccountAsset.Residency
+	}
+	return countryOf(bankAccountAsset.CentralBank)
+}
+
+// SetTaxRule sets the withholding rate for a payment type between a payer and a payee country
+func (s *SmartContract) SetTaxRule(ctx contractapi.TransactionContextInterface, paymentType string, payerCountry string, payeeCountry string, ratePercent float64) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	if paymentType != PaymentWages && paymentType != PaymentTransfer {
+		return fmt.Errorf("tax rules can only be set for %s and %s payments", PaymentWages, PaymentTransfer)
+	}
+	if ratePercent < 0 || ratePercent > 100 {
+		return fmt.Errorf("tax rate must be between 0 and 100 percent")
+	}
+
+	rule := TaxRule{
+		PaymentType:  paymentType,
+		PayerCountry: strings.ToUpper(payerCountry),
+		PayeeCountry: strings.ToUpper(payeeCountry),
+		RatePercent:  ratePercent,
+	}
+
+	ruleKey, err := ctx.GetStub().CreateCompositeKey("taxrule", []string{rule.PaymentType, rule.PayerCountry, rule.PayeeCountry})
+	if err != nil {
+		return err
+	}
+	ruleJSON, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(ruleKey, ruleJSON)
+}
+
+// RemoveTaxRule deletes a withholding tax rule
+func (s *SmartContract) RemoveTaxRule(ctx contractapi.TransactionContextInterface, paymentType string, payerCountry string, payeeCountry string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	ruleKey, err := ctx.GetStub().CreateCompositeKey("taxrule", []string{paymentType, strings.ToUpper(payerCountry), strings.ToUpper(payeeCountry)})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(ruleKey)
+}
+
+// GetTaxRules returns every withholding tax rule
+func (s *SmartContract) GetTaxRules(ctx contractapi.TransactionContextInterface) ([]TaxRule, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("taxrule", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	rules := []TaxRule{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var rule TaxRule
+		if err := json.Unmarshal(queryResponse.Value, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// SetResidency sets the tax residency country of an account
+func (s *SmartContract) SetResidency(ctx contractapi.TransactionContextInterface, accountNo string, country string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return err
+	}
+
+	bankAccountAsset.Residency = strings.ToUpper(country)
+
+	bankAccountAssetJSON, err := json.Marshal(bankAccountAsset)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(accountNo, bankAccountAssetJSON)
+}
+
+// withholdingRate returns the percentage to withhold from a credit to the payee.
+// Treaty rules for the exact corridor win over the payee country's default rule.
+// Wages with no matching rule fall back to the account's flat Tax percentage.
+func (s *SmartContract) withholdingRate(ctx contractapi.TransactionContextInterface, payee *BankAccountAsset, paymentType string, payerCountry string) (float64, error) {
+	if paymentType == PaymentRefund {
+		return 0, nil
+	}
+
+	payeeCountry := residencyOf(payee)
+	for _, payer := range []string{strings.ToUpper(payerCountry), "*"} {
+		ruleKey, err := ctx.GetStub().CreateCompositeKey("taxrule", []string{paymentType, payer, payeeCountry})
+		if err != nil {
+			return 0, err
+		}
+		ruleJSON, err := ctx.GetStub().GetState(ruleKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read tax rule from world state: %v", err)
+		}
+		if ruleJSON != nil {
+			var rule TaxRule
+			if err := json.Unmarshal(ruleJSON, &rule); err != nil {
+				return 0, err
+			}
+			return rule.RatePercent, nil
+		}
+	}
+
+	if paymentType == PaymentWages {
+		return float64(payee.Tax), nil
+	}
+
+	return 0, nil
+}
+
+// putTaxEntry stores the tax entry of a credit under its own key for the payee's fiscal year.
+// The index tells apart the entries of several credits made by one transaction.
+func (s *SmartContract) putTaxEntry(ctx contractapi.TransactionContextInterface, payee *BankAccountAsset, entry TaxEntry, index int) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	entry.TxId = ctx.GetStub().GetTxID()
+	entry.Date = now.Format(time.RFC3339)
+
+	year := fiscalYearOf(residencyOf(payee), now)
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey("taxentry", []string{payee.AccountNo, fmt.Sprintf("%d", year), entry.TxId, fmt.Sprintf("%d", index)})
+	if err != nil {
+		return err
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(entryKey, entryJSON)
+}
+
+// putTaxDelta records tax withheld from a credit. The tax is not added to the withholding account directly,
+// which every credit would then contend for, but written as a delta under its own key,
+// taxdelta~currency~txId~index, where the index tells apart the credits of one call.
+// CompactTaxAccount folds the deltas into the withholding account.
+func (s *SmartContract) putTaxDelta(ctx contractapi.TransactionContextInterface, currency string, withheld int, index int) error {
+	deltaKey, err := ctx.GetStub().CreateCompositeKey("taxdelta", []string{strings.ToUpper(currency), ctx.GetStub().GetTxID(), strconv.Itoa(index)})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(deltaKey, []byte(strconv.Itoa(withheld)))
+}
+
+// eachTaxDelta calls fn with the key and amount of every tax withheld in a currency and not yet compacted
+func (s *SmartContract) eachTaxDelta(ctx contractapi.TransactionContextInterface, currency string, fn func(key string, withheld int) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("taxdelta", []string{strings.ToUpper(currency)})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		withheld, err := strconv.Atoi(string(queryResponse.Value))
+		if err != nil {
+			return fmt.Errorf("failed to convert tax delta to int: %v", err)
+		}
+		if err := fn(queryResponse.Key, withheld); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CompactTaxAccount folds the tax withheld in a currency into the withholding account
+// and returns the number of credits folded
+func (s *SmartContract) CompactTaxAccount(ctx contractapi.TransactionContextInterface, currency string) (int, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	withholdingAccount, err := s.readTaxAccount(ctx, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	compacted := 0
+	err = s.eachTaxDelta(ctx, currency, func(key string, withheld int) error {
+		adjustBalance(withholdingAccount, currency, withheld)
+		compacted++
+		return ctx.GetStub().DelState(key)
+	})
+	if err != nil || compacted == 0 {
+		return 0, err
+	}
+
+	return compacted, s.putTaxAccount(ctx, withholdingAccount)
+}
+
+// GetTaxAccount returns the bank's tax withholding account for a currency,
+// including the tax withheld that has not been compacted yet
+func (s *SmartContract) GetTaxAccount(ctx contractapi.TransactionContextInterface, currency string) (*BankAccountAsset, error) {
+	withholdingAccount, err := s.readTaxAccount(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.eachTaxDelta(ctx, currency, func(key string, withheld int) error {
+		adjustBalance(withholdingAccount, currency, withheld)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return withholdingAccount, nil
+}
+
+// readTaxAccount returns the tax withholding account for a currency, which is stored under a composite key
+// that no customer account can take. A missing account starts empty.
+func (s *SmartContract) readTaxAccount(ctx contractapi.TransactionContextInterface, currency string) (*BankAccountAsset, error) {
+	currency = strings.ToUpper(currency)
+	accountKey, err := ctx.GetStub().CreateCompositeKey("taxaccount", []string{currency})
+	if err != nil {
+		return nil, err
+	}
+	accountJSON, err := ctx.GetStub().GetState(accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tax account from world state: %v", err)
+	}
+	if accountJSON == nil {
+		return &BankAccountAsset{
+			AccountNo:   "TAX-" + currency,
+			CentralBank: currency,
+			Balances:    map[string]int{},
+			Owner:       taxAuthority,
+			Status:      StatusActive,
+		}, nil
+	}
+
+	var withholdingAccount BankAccountAsset
+	if err := json.Unmarshal(accountJSON, &withholdingAccount); err != nil {
+		return nil, err
+	}
+	normalizeAccount(&withholdingAccount)
+
+	return &withholdingAccount, nil
+}
+
+// putTaxAccount stores a tax withholding account
+func (s *SmartContract) putTaxAccount(ctx contractapi.TransactionContextInterface, withholdingAccount *BankAccountAsset) error {
+	accountKey, err := ctx.GetStub().CreateCompositeKey("taxaccount", []string{homeCurrency(withholdingAccount)})
+	if err != nil {
+		return err
+	}
+	accountJSON, err := json.Marshal(withholdingAccount)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(accountKey, accountJSON)
+}
+
+// readTaxCertificate assembles the certificate of a fiscal year from the account's tax entries
+func (s *SmartContract) readTaxCertificate(ctx contractapi.TransactionContextInterface, bankAccountAsset *BankAccountAsset, year int) (*TaxCertificate, error) {
+	residency := residencyOf(bankAccountAsset)
+	startMonth, ok := fiscalYearStartMonths[residency]
+	if !ok {
+		startMonth = time.January
+	}
+	periodStart := time.Date(year, startMonth, 1, 0, 0, 0, 0, time.UTC)
+
+	certificate := &TaxCertificate{
+		AccountNo:   bankAccountAsset.AccountNo,
+		Owner:       bankAccountAsset.Owner,
+		Residency:   residency,
+		FiscalYear:  year,
+		PeriodStart: periodStart.Format("2006-01-02"),
+		PeriodEnd:   periodStart.AddDate(1, 0, -1).Format("2006-01-02"),
+		Gross:       map[string]int{},
+		Withheld:    map[string]int{},
+		Entries:     []TaxEntry{},
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("taxentry", []string{bankAccountAsset.AccountNo, fmt.Sprintf("%d", year)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry TaxEntry
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return nil, err
+		}
+		certificate.Gross[entry.Currency] += entry.Gross
+		certificate.Withheld[entry.Currency] += entry.Withheld
+		certificate.Entries = append(certificate.Entries, entry)
+	}
+
+	return certificate, nil
+}
+
+// GetTaxCertificate returns the tax withheld from an account in the fiscal year starting in the given year
+func (s *SmartContract) GetTaxCertificate(ctx contractapi.TransactionContextInterface, accountNo string, year int) (*TaxCertificate, error) {
+	bankAccountAsset, err := s.readBankAccountAsset(ctx, accountNo)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.readTaxCertificate(ctx, bankAccountAsset, year)
+}
@@ -107,6 +107,29 @@ async function main(): Promise<void> {
         await initLedger(usdContract);
         await initLedger(inrContract);
 
+        // Open reserve accounts for the commercial banks at both central banks and let their clients act for them.
+        for (const centralBank of [usdContract, inrContract]) {
+            for (const bank of ['adfc', 'ibibi', 'yesbi']) {
+                await registerMemberBank(centralBank, bank, 1000000000);
+                await registerMemberBankMSP(centralBank, bank, mspId);
+            }
+        }
+
+        // Let the central banks, and the member banks whose payments they forward, deliver credits to each bank.
+        for (const bank of [adfcContract, ibibiContract, yesbiContract]) {
+            for (const centralBank of ['usd', 'inr']) {
+                await registerCentralBankMSP(bank, centralBank, mspId);
+            }
+        }
+
+        // Let each central bank, and the commercial banks whose payments it forwards, deliver payments to the other.
+        await registerCorrespondentMSP(usdContract, 'inr', mspId);
+        await registerCorrespondentMSP(inrContract, 'usd', mspId);
+
+        // Fund each central bank's account at the other for the forex leg of cross-border payments.
+        await openVostro(usdContract, 'inr', 1000000000);
+        await openVostro(inrContract, 'usd', 1000000000);
+
         app.post('/acceptByContractor', async (req:any, res:any) => {
             const { contractId, contractor, manager } = req.body;
             try {
@@ -199,6 +222,18 @@ async function main(): Promise<void> {
             }
         });
 
+        app.get('/completedContracts/:username', async (req:any, res:any) => {
+            const { username } = req.params;
+            try {
+                // Call the GetCompletedContracts function on the smart contract.
+                const result = await getCompletedContracts(contract, username);
+                res.status(200).json(result);
+            } catch (error) {
+                console.error('Error getting completed contracts:', error);
+                res.status(500).json({ error: 'Failed to get completed contracts' });
+            }
+        });
+
         app.get('/requestedContracts/:username', async (req:any, res:any) => {
             const { username } = req.params;
             try {
@@ -224,10 +259,10 @@ async function main(): Promise<void> {
         });
 
         app.post('/createContractAsset', async (req:any, res:any) => {
-            const { manager, contractor, duration, interval, ratePerInterval, natureOfWork, startDate } = req.body;
+            const { manager, contractor, duration, interval, ratePerInterval, natureOfWork, startDate, intervalUnit, timeZone, proRate, autoRenew, payBasis } = req.body;
             try {
                 // Call the CreateContractAsset function on the smart contract.
-                await createContractAsset(contract, manager, contractor, duration, interval, ratePerInterval, natureOfWork, startDate);
+                await createContractAsset(contract, manager, contractor, duration, interval, ratePerInterval, natureOfWork, startDate, intervalUnit ?? '', timeZone ?? '', proRate ?? '', Boolean(autoRenew), payBasis ?? '');
                 res.status(200).json({ message: 'Contract asset created successfully' });
             } catch (error) {
                 console.error('Error creating contract asset:', error);
@@ -248,11 +283,36 @@ async function main(): Promise<void> {
             }
         });
 
+        app.post('/compactBalance', async (req:any, res:any) => {
+            const { bank, accountNo } = req.body;
+            try {
+                // Call the CompactBalance function on the smart contract.
+                const compacted = await compactBalance(contractMap.get(bank), accountNo);
+                res.status(200).json({ message: 'Balance compacted successfully', compacted });
+            } catch (error) {
+                console.error('Error compacting balance:', error);
+                res.status(500).json({ error: 'Failed to compact balance' });
+            }
+        });
+
+        app.post('/compactTaxAccount', async (req:any, res:any) => {
+            const { bank, currency } = req.body;
+            try {
+                // Call the CompactTaxAccount function on the smart contract.
+                const compacted = await compactTaxAccount(contractMap.get(bank), currency);
+                res.status(200).json({ message: 'Tax account compacted successfully', compacted });
+            } catch (error) {
+                console.error('Error compacting tax account:', error);
+                res.status(500).json({ error: 'Failed to compact tax account' });
+            }
+        });
+
         app.get('/invokeForex/:currencyFrom/:currencyTo/:amount', async (req:any, res:any) => {
             const { currencyFrom, currencyTo, amount } = req.params;
+            const { bankFrom = 'usd' } = req.query;
             try {
                 // Call the InvokeForex function on the smart contract.
-                const result = await invokeForex(usdContract, currencyFrom, currencyTo, amount);
+                const result = await invokeForex(usdContract, currencyFrom, currencyTo, amount, bankFrom);
                 res.status(200).json({ message: 'Forex invoked successfully', result });
             } catch (error) {
                 console.error('Error invoking forex:', error);
@@ -260,6 +320,19 @@ async function main(): Promise<void> {
             }
         });
 
+        app.get('/previewPayment/:bankFrom/:bankAccountFrom/:currencyFrom/:currencyTo/:amount/:bankTo/:bankAccountTo', async (req:any, res:any) => {
+            const { bankFrom, bankAccountFrom, currencyFrom, currencyTo, amount, bankTo, bankAccountTo } = req.params;
+            const { paymentType = 'wages', quoteId = '' } = req.query;
+            try {
+                // Call the PreviewPayment function on the payer's bank smart contract.
+                const result = await previewPayment(contractMap.get(bankFrom), currencyFrom, currencyTo, amount, bankAccountFrom, bankTo, bankAccountTo, paymentType, quoteId);
+                res.status(200).json(result);
+            } catch (error) {
+                console.error('Error previewing payment:', error);
+                res.status(500).json({ error: 'Failed to preview payment' });
+            }
+        });
+
         app.put('/addFunds', async (req:any, res:any) => {
             const { accountNo, amount, bank } = req.body;
             try {
@@ -289,7 +362,7 @@ async function main(): Promise<void> {
                 // Call the revoke function on the smart contract.
                 const amount = await calculateRedemptionAmount(contract, contractId, manager, contractor, currentDate);
                 if(amount != 0){
-                    await pay(contractMap.get(bankFrom), currencyFrom, currencyTo, amount, bankAccountFrom, bankTo.toLowerCase(), bankAccountTo);
+                    await pay(contractMap.get(bankFrom), currencyFrom, currencyTo, amount, bankAccountFrom, bankTo.toLowerCase(), bankAccountTo, 'wages');
                 }
                 await revoke(contract, contractId, manager, contractor);
                 res.status(200).json({ message: 'Contract revoked successfully' });
@@ -322,25 +395,326 @@ async function main(): Promise<void> {
                 res.status(500).json({ error: 'Failed to remove contract from requested' });
             }
         });
+
+        app.put('/setContractParties', async (req:any, res:any) => {
+            const { contractId, contractor, payees, intermediaries } = req.body;
+            try {
+                // Call the setContractParties function on the smart contract.
+                await setContractParties(contract, contractId, contractor, payees ?? [], intermediaries ?? []);
+                res.status(200).json({ message: 'Contract parties set successfully' });
+            } catch (error) {
+                console.error('Error setting contract parties:', error);
+                res.status(500).json({ error: 'Failed to set contract parties' });
+            }
+        });
+
+        app.put('/rejectByContractor', async (req:any, res:any) => {
+            const { contractId, contractor, reason } = req.body;
+            try {
+                // Call the rejectByContractor function on the smart contract.
+                await rejectByContractor(contract, contractId, contractor, reason ?? '');
+                res.status(200).json({ message: 'Contract rejected successfully' });
+            } catch (error) {
+                console.error('Error rejecting contract:', error);
+                res.status(500).json({ error: 'Failed to reject contract' });
+            }
+        });
+
+        app.post('/counterOffer', async (req:any, res:any) => {
+            const { contractId, contractor, newTerms } = req.body;
+            try {
+                // Call the counterOffer function on the smart contract.
+                await counterOffer(contract, contractId, contractor, newTerms);
+                res.status(200).json({ message: 'Counter offer sent successfully' });
+            } catch (error) {
+                console.error('Error sending counter offer:', error);
+                res.status(500).json({ error: 'Failed to send counter offer' });
+            }
+        });
+
+        app.post('/raiseDispute', async (req:any, res:any) => {
+            const { contractId, username, reason } = req.body;
+            try {
+                // Call the raiseDispute function on the smart contract.
+                const dispute = await raiseDispute(contract, contractId, username, reason ?? '');
+                res.status(200).json({ message: 'Dispute raised successfully', dispute });
+            } catch (error) {
+                console.error('Error raising dispute:', error);
+                res.status(500).json({ error: 'Failed to raise dispute' });
+            }
+        });
+
+        app.post('/submitEvidence', async (req:any, res:any) => {
+            const { disputeId, username, documentHash, description } = req.body;
+            try {
+                // Call the submitEvidence function on the smart contract.
+                await submitEvidence(contract, disputeId, username, documentHash, description ?? '');
+                res.status(200).json({ message: 'Evidence submitted successfully' });
+            } catch (error) {
+                console.error('Error submitting evidence:', error);
+                res.status(500).json({ error: 'Failed to submit evidence' });
+            }
+        });
+
+        app.post('/resolveDispute', async (req:any, res:any) => {
+            const { disputeId, releasePercent, additionalAmount, resolution } = req.body;
+            try {
+                // Call the resolveDispute function on the smart contract.
+                const result = await resolveDispute(contract, disputeId, releasePercent, additionalAmount ?? 0, resolution ?? '');
+                const currency = result.dispute.currency;
+                // The escrowed amount is released to the payees and refunded to the manager from the escrow account
+                for (const split of result.escrowPayments) {
+                    if (split.amount > 0) {
+                        await pay(contractMap.get(result.escrow.bank.toLowerCase()), currency, split.currency, split.amount, result.escrow.bankAccountNo, split.bank.toLowerCase(), split.bankAccountNo, split.paymentType);
+                    }
+                }
+                // Any additional award is paid by the manager
+                for (const split of result.additionalPayments) {
+                    if (split.amount > 0) {
+                        await pay(contractMap.get(result.managerBank.toLowerCase()), currency, split.currency, split.amount, result.managerBankAccountNo, split.bank.toLowerCase(), split.bankAccountNo, split.paymentType);
+                    }
+                }
+                res.status(200).json({ message: 'Dispute resolved successfully', dispute: result.dispute });
+            } catch (error) {
+                console.error('Error resolving dispute:', error);
+                res.status(500).json({ error: 'Failed to resolve dispute' });
+            }
+        });
+
+        app.get('/disputes/:contractId', async (req:any, res:any) => {
+            const { contractId } = req.params;
+            try {
+                // Call the getContractDisputes function on the smart contract.
+                const disputes = await getContractDisputes(contract, contractId);
+                res.status(200).json(disputes);
+            } catch (error) {
+                console.error('Error fetching disputes:', error);
+                res.status(500).json({ error: 'Failed to fetch disputes' });
+            }
+        });
+
+        app.post('/submitTimesheet', async (req:any, res:any) => {
+            const { contractId, contractor, hours, periodStart, periodEnd, description } = req.body;
+            try {
+                // Call the submitTimesheet function on the smart contract.
+                const submission = await submitTimesheet(contract, contractId, contractor, hours, periodStart, periodEnd, description ?? '');
+                res.status(200).json({ message: 'Timesheet submitted successfully', submission });
+            } catch (error) {
+                console.error('Error submitting timesheet:', error);
+                res.status(500).json({ error: 'Failed to submit timesheet' });
+            }
+        });
+
+        app.post('/submitDeliverable', async (req:any, res:any) => {
+            const { contractId, contractor, documentHash, description } = req.body;
+            try {
+                // Call the submitDeliverable function on the smart contract.
+                const submission = await submitDeliverable(contract, contractId, contractor, documentHash, description ?? '');
+                res.status(200).json({ message: 'Deliverable submitted successfully', submission });
+            } catch (error) {
+                console.error('Error submitting deliverable:', error);
+                res.status(500).json({ error: 'Failed to submit deliverable' });
+            }
+        });
+
+        app.put('/approveSubmission', async (req:any, res:any) => {
+            const { contractId, submissionId, manager } = req.body;
+            try {
+                // Call the approveSubmission function on the smart contract.
+                await approveSubmission(contract, contractId, submissionId, manager);
+                res.status(200).json({ message: 'Submission approved successfully' });
+            } catch (error) {
+                console.error('Error approving submission:', error);
+                res.status(500).json({ error: 'Failed to approve submission' });
+            }
+        });
+
+        app.put('/rejectSubmission', async (req:any, res:any) => {
+            const { contractId, submissionId, manager, reason } = req.body;
+            try {
+                // Call the rejectSubmission function on the smart contract.
+                await rejectSubmission(contract, contractId, submissionId, manager, reason ?? '');
+                res.status(200).json({ message: 'Submission rejected successfully' });
+            } catch (error) {
+                console.error('Error rejecting submission:', error);
+                res.status(500).json({ error: 'Failed to reject submission' });
+            }
+        });
+
+        app.get('/submissions/:contractId', async (req:any, res:any) => {
+            const { contractId } = req.params;
+            try {
+                // Call the getSubmissions function on the smart contract.
+                const submissions = await getSubmissions(contract, contractId);
+                res.status(200).json(submissions);
+            } catch (error) {
+                console.error('Error fetching submissions:', error);
+                res.status(500).json({ error: 'Failed to fetch submissions' });
+            }
+        });
+
+        app.put('/updateUserProfile', async (req:any, res:any) => {
+            const { username, password, name, bank, bankAccount, centralBank, company, repointContracts } = req.body;
+            try {
+                // Call the updateUserProfile function on the smart contract.
+                await updateUserProfile(contract, username, password, name, bank, bankAccount, centralBank, company, repointContracts ?? false);
+                res.status(200).json({ message: 'User profile updated successfully' });
+            } catch (error) {
+                console.error('Error updating user profile:', error);
+                res.status(500).json({ error: 'Failed to update user profile' });
+            }
+        });
+
+        app.post('/requestAccountChange', async (req:any, res:any) => {
+            const { contractId, username } = req.body;
+            try {
+                // Call the requestAccountChange function on the smart contract.
+                await requestAccountChange(contract, contractId, username);
+                res.status(200).json({ message: 'Account change requested successfully' });
+            } catch (error) {
+                console.error('Error requesting account change:', error);
+                res.status(500).json({ error: 'Failed to request account change' });
+            }
+        });
+
+        app.post('/approveAccountChange', async (req:any, res:any) => {
+            const { contractId, username, approver } = req.body;
+            try {
+                // Call the approveAccountChange function on the smart contract.
+                await approveAccountChange(contract, contractId, username, approver);
+                res.status(200).json({ message: 'Account change approved successfully' });
+            } catch (error) {
+                console.error('Error approving account change:', error);
+                res.status(500).json({ error: 'Failed to approve account change' });
+            }
+        });
+
+        app.post('/rejectAccountChange', async (req:any, res:any) => {
+            const { contractId, username, approver, reason } = req.body;
+            try {
+                // Call the rejectAccountChange function on the smart contract.
+                await rejectAccountChange(contract, contractId, username, approver, reason ?? '');
+                res.status(200).json({ message: 'Account change rejected successfully' });
+            } catch (error) {
+                console.error('Error rejecting account change:', error);
+                res.status(500).json({ error: 'Failed to reject account change' });
+            }
+        });
+
+        app.get('/accountChange/:contractId/:username', async (req:any, res:any) => {
+            const { contractId, username } = req.params;
+            try {
+                // Call the getAccountChange function on the smart contract.
+                const result = await getAccountChange(contract, contractId, username);
+                res.status(200).json(result);
+            } catch (error) {
+                console.error('Error getting account change:', error);
+                res.status(500).json({ error: 'Failed to get account change' });
+            }
+        });
+
+        app.put('/registerPublicKey', async (req:any, res:any) => {
+            const { username, password, publicKey } = req.body;
+            try {
+                // Call the registerPublicKey function on the smart contract.
+                await registerPublicKey(contract, username, password, publicKey);
+                res.status(200).json({ message: 'Public key registered successfully' });
+            } catch (error) {
+                console.error('Error registering public key:', error);
+                res.status(500).json({ error: 'Failed to register public key' });
+            }
+        });
+
+        app.put('/attachContractDocument', async (req:any, res:any) => {
+            const { contractId, manager, contractor, documentHash } = req.body;
+            try {
+                // Call the attachContractDocument function on the smart contract.
+                await attachContractDocument(contract, contractId, manager, contractor, documentHash);
+                res.status(200).json({ message: 'Contract document attached successfully' });
+            } catch (error) {
+                console.error('Error attaching contract document:', error);
+                res.status(500).json({ error: 'Failed to attach contract document' });
+            }
+        });
+
+        app.get('/contractTerms/:contractId', async (req:any, res:any) => {
+            const { contractId } = req.params;
+            try {
+                // Call the getContractTerms function on the smart contract.
+                const terms = await getContractTerms(contract, contractId);
+                res.status(200).json({ terms });
+            } catch (error) {
+                console.error('Error fetching contract terms:', error);
+                res.status(500).json({ error: 'Failed to fetch contract terms' });
+            }
+        });
+
+        app.post('/signContract', async (req:any, res:any) => {
+            const { contractId, username, signature } = req.body;
+            try {
+                // Call the signContract function on the smart contract.
+                await signContract(contract, contractId, username, signature);
+                res.status(200).json({ message: 'Contract signed successfully' });
+            } catch (error) {
+                console.error('Error signing contract:', error);
+                res.status(500).json({ error: 'Failed to sign contract' });
+            }
+        });
+
+        app.get('/verifyContractDocument/:contractId/:hash', async (req:any, res:any) => {
+            const { contractId, hash } = req.params;
+            try {
+                // Call the verifyContractDocument function on the smart contract.
+                const verification = await verifyContractDocument(contract, contractId, hash);
+                res.status(200).json(verification);
+            } catch (error) {
+                console.error('Error verifying contract document:', error);
+                res.status(500).json({ error: 'Failed to verify contract document' });
+            }
+        });
         
 
         app.post('/pay', async (req:any, res:any) => {
-            const { currencyFrom, currencyTo, bankFrom, bankAccountFrom, bankTo, bankAccountTo, currentDate, contractor, manager, contractId } = req.body;
+            const { currencyFrom, bankFrom, bankAccountFrom, currentDate, contractor, manager, contractId } = req.body;
             try {
                 // Call the pay function on the smart contract.
-                const amount = await calculateRedemptionAmount(contract, contractId, manager, contractor, currentDate);
+                const { amount, txId: redemption } = await redeem(contract, contractId, manager, contractor, currentDate);
                 if(amount == 0){
                     res.status(400).json({ error: 'No money left to redeem' });
                     return;
                 }
-                await pay(contractMap.get(bankFrom), currencyFrom, currencyTo, amount, bankAccountFrom, bankTo.toLowerCase(), bankAccountTo);
-                res.status(200).json({ message: 'Payment successful' });
+                // Each payee and intermediary on the contract is paid its split in its own currency and bank
+                const splits = await getPaymentSplits(contract, contractId, manager, amount);
+                const payments = await paySplits(contract, contractMap.get(bankFrom), currencyFrom, bankAccountFrom, contractId, manager, redemption, splits);
+                if (payments.some(payment => payment.status !== 'Paid')) {
+                    // The redemption is committed; /resumePayment pays the splits still owed
+                    res.status(502).json({ error: 'Some splits were not paid', redemption, amount, payments });
+                    return;
+                }
+                res.status(200).json({ message: 'Payment successful', redemption, payments });
             } catch (error) {
                 console.error('Error making payment:', error);
                 res.status(500).json({ error: 'Failed to make payment' });
             }
         });
 
+        // Endpoint to pay the splits of a redemption that an earlier payment left unpaid
+        app.post('/resumePayment', async (req:any, res:any) => {
+            const { currencyFrom, bankFrom, bankAccountFrom, manager, contractId, redemption, amount } = req.body;
+            try {
+                const splits = await getPaymentSplits(contract, contractId, manager, amount);
+                const payments = await paySplits(contract, contractMap.get(bankFrom), currencyFrom, bankAccountFrom, contractId, manager, redemption, splits);
+                if (payments.some(payment => payment.status !== 'Paid' && payment.status !== 'AlreadyPaid')) {
+                    res.status(502).json({ error: 'Some splits were not paid', redemption, amount, payments });
+                    return;
+                }
+                res.status(200).json({ message: 'Payment successful', redemption, payments });
+            } catch (error) {
+                console.error('Error resuming payment:', error);
+                res.status(500).json({ error: 'Failed to resume payment' });
+            }
+        });
+
         // Endpoint to calculate redemption amount
         app.get('/calculateRedemptionAmount/:contractId/:manager/:contractor/:currentDate', async (req:any, res:any) => {
             const { contractId, manager, contractor , currentDate } = req.params;
@@ -353,6 +727,31 @@ async function main(): Promise<void> {
                 res.status(500).json({ error: 'Failed to calculate redemption amount' });
             }
         });
+
+        // Endpoint to run payroll for every contract due as of a date
+        app.post('/runPayroll', async (req:any, res:any) => {
+            const { asOfDate } = req.body;
+            try {
+                const { run, txId } = await runPayroll(contract, asOfDate ?? '');
+                // A run recorded by an earlier transaction has already been paid
+                if (run.txId !== txId) {
+                    res.status(200).json({ message: 'Payroll already run', run });
+                    return;
+                }
+                const payments = [];
+                for (const entry of run.entries) {
+                    if (entry.status !== 'Redeemed') {
+                        continue;
+                    }
+                    // The run's transaction is the redemption the splits are recorded against
+                    payments.push(...await paySplits(contract, contractMap.get(entry.managerBank), entry.rateCurrency, entry.managerBankAccountNo, entry.contractId, entry.manager, run.txId, entry.payments));
+                }
+                res.status(200).json({ message: 'Payroll run successfully', run, payments });
+            } catch (error) {
+                console.error('Error running payroll:', error);
+                res.status(500).json({ error: 'Failed to run payroll' });
+            }
+        });
         
 
     }
@@ -406,6 +805,46 @@ async function initLedger(contract: Contract): Promise<void> {
     console.log('*** Transaction committed successfully');
 }
 
+async function registerMemberBank(contract: Contract, bank: string, initialReserve: number): Promise<void> {
+    console.log(`\n--> Submit Transaction: RegisterMemberBank, function opens a reserve account for bank ${bank}`);
+    try {
+        await contract.submitTransaction('RegisterMemberBank', bank, initialReserve.toString());
+        console.log('*** Transaction committed successfully');
+    } catch (error) {
+        // The bank is already a member when the server restarts against an existing ledger
+        console.log('*** Bank not registered:', error);
+    }
+}
+
+async function registerCentralBankMSP(contract: Contract, centralBank: string, mspID: string): Promise<void> {
+    console.log(`\n--> Submit Transaction: RegisterCentralBankMSP, function lets members of ${mspID} act for central bank ${centralBank}`);
+    await contract.submitTransaction('RegisterCentralBankMSP', centralBank, mspID);
+    console.log('*** Transaction committed successfully');
+}
+
+async function registerMemberBankMSP(contract: Contract, bank: string, mspID: string): Promise<void> {
+    console.log(`\n--> Submit Transaction: RegisterMemberBankMSP, function lets members of ${mspID} act for bank ${bank}`);
+    await contract.submitTransaction('RegisterMemberBankMSP', bank, mspID);
+    console.log('*** Transaction committed successfully');
+}
+
+async function registerCorrespondentMSP(contract: Contract, centralBank: string, mspID: string): Promise<void> {
+    console.log(`\n--> Submit Transaction: RegisterCorrespondentMSP, function lets members of ${mspID} act for central bank ${centralBank}`);
+    await contract.submitTransaction('RegisterCorrespondentMSP', centralBank, mspID);
+    console.log('*** Transaction committed successfully');
+}
+
+async function openVostro(contract: Contract, centralBank: string, initialBalance: number): Promise<void> {
+    console.log(`\n--> Submit Transaction: OpenVostro, function opens the account central bank ${centralBank} holds here`);
+    try {
+        await contract.submitTransaction('OpenVostro', centralBank, initialBalance.toString());
+        console.log('*** Transaction committed successfully');
+    } catch (error) {
+        // The account is already open when the server restarts against an existing ledger
+        console.log('*** Vostro account not opened:', error);
+    }
+}
+
 async function createUserAsset(contract: Contract, username: string, name: string, password: string, bank: string, bankAccountNo: string, centralBankID: string, company: string): Promise<void> {
     console.log('\n--> Submit Transaction: CreateUserAsset, function creates the initial set of assets on the ledger');
 
@@ -461,6 +900,15 @@ async function getContracts(contract: Contract, username: string): Promise<any>
     return result;
 }
 
+async function getCompletedContracts(contract: Contract, username: string): Promise<any> {
+    console.log('\n--> Evaluate Transaction: GetCompletedContracts, function returns completed contracts for a given username');
+    const resultBytes = await contract.evaluateTransaction('GetCompletedContracts', username);
+    const resultJson = utf8Decoder.decode(resultBytes);
+    const result = JSON.parse(resultJson);
+    console.log('*** Result:', result);
+    return result;
+}
+
 async function getRequestedContracts(contract: Contract, username: string): Promise<any> {
     console.log('\n--> Evaluate Transaction: GetContracts, function returns contracts for a given username');
     const resultBytes = await contract.evaluateTransaction('GetRequestedContracts', username);
@@ -480,7 +928,7 @@ async function getPendingContracts(contract: Contract, username: string): Promis
     return result;
 }
 
-async function createContractAsset(contract: Contract, manager: string, contractor: string, duration: string, interval: string, ratePerInterval: string, natureOfWork: string, startDate: string): Promise<void> {
+async function createContractAsset(contract: Contract, manager: string, contractor: string, duration: string, interval: string, ratePerInterval: string, natureOfWork: string, startDate: string, intervalUnit: string, timeZone: string, proRate: string, autoRenew: boolean, payBasis: string): Promise<void> {
     console.log('\n--> Submit Transaction: CreateContractAsset, function creates a new contract asset on the ledger');
     await contract.submitTransaction(
         'CreateContractAsset',
@@ -490,7 +938,12 @@ async function createContractAsset(contract: Contract, manager: string, contract
         interval,
         ratePerInterval,
         natureOfWork,
-        startDate
+        startDate,
+        intervalUnit,
+        timeZone,
+        proRate,
+        autoRenew.toString(),
+        payBasis
     );
 }
 
@@ -522,9 +975,34 @@ async function createBankAccountAsset(contract: Contract, accountNo: string, cen
     console.log('*** Transaction committed successfully');
 }
 
-async function invokeForex(contract: Contract, currencyFrom: string, currencyTo: string, amount: number): Promise<number> {
+async function compactBalance(contract: Contract, accountNo: string): Promise<number> {
+    console.log('\n--> Submit Transaction: CompactBalance, function folds pending credits into the balance of a high-throughput account');
+    const resultBytes = await contract.submitTransaction('CompactBalance', accountNo);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Transaction committed successfully');
+    return result;
+}
+
+async function compactTaxAccount(contract: Contract, currency: string): Promise<number> {
+    console.log('\n--> Submit Transaction: CompactTaxAccount, function folds withheld tax into the withholding account of a currency');
+    const resultBytes = await contract.submitTransaction('CompactTaxAccount', currency);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Transaction committed successfully');
+    return result;
+}
+
+async function invokeForex(contract: Contract, currencyFrom: string, currencyTo: string, amount: number, bankFrom: string): Promise<number> {
     console.log('\n--> Submit Transaction: InvokeForex, function invokes the forex smart contract');
-    const resultBytes = await contract.evaluateTransaction('InvokeForex', currencyFrom, currencyTo, amount.toString());
+    const resultBytes = await contract.evaluateTransaction('InvokeForex', currencyFrom, currencyTo, amount.toString(), bankFrom);
+    const resultJson = utf8Decoder.decode(resultBytes);
+    const result = JSON.parse(resultJson);
+    console.log('*** Result:', result);
+    return result;
+}
+
+async function previewPayment(contract: Contract, currencyFrom: string, currencyTo: string, amount: number, bankAccountFrom: string, bankTo: string, bankAccountTo: string, paymentType: string, quoteId: string): Promise<any> {
+    console.log('\n--> Evaluate Transaction: PreviewPayment, function returns the fees and tax a payment would incur');
+    const resultBytes = await contract.evaluateTransaction('PreviewPayment', currencyFrom, currencyTo, amount.toString(), bankAccountFrom, bankTo, bankAccountTo, paymentType, quoteId);
     const resultJson = utf8Decoder.decode(resultBytes);
     const result = JSON.parse(resultJson);
     console.log('*** Result:', result);
@@ -561,18 +1039,231 @@ async function removeFromRequestedOfContractor(contract: Contract, contractId: n
     console.log('*** Transaction committed successfully');
 }
 
-async function pay(contract: Contract, currencyFrom: string, currencyTo: string, amount: number, bankAccountFrom: string, bankTo: string, bankAccountTo: string): Promise<void> {
+async function setContractParties(contract: Contract, contractId: number, contractor: string, payees: any[], intermediaries: any[]): Promise<void> {
+    console.log(`\n--> Submit Transaction: SetContractParties, function sets the payees and intermediaries of a requested contract`);
+    await contract.submitTransaction('SetContractParties', contractId.toString(), contractor, JSON.stringify(payees), JSON.stringify(intermediaries));
+    console.log('*** Transaction committed successfully');
+}
+
+async function rejectByContractor(contract: Contract, contractId: number, contractor: string, reason: string): Promise<void> {
+    console.log(`\n--> Submit Transaction: RejectByContractor, function rejects a requested contract and notifies the manager`);
+    await contract.submitTransaction('RejectByContractor', contractId.toString(), contractor, reason);
+    console.log('*** Transaction committed successfully');
+}
+
+async function counterOffer(contract: Contract, contractId: number, contractor: string, newTerms: any): Promise<void> {
+    console.log(`\n--> Submit Transaction: CounterOffer, function sends revised terms back to the manager`);
+    await contract.submitTransaction('CounterOffer', contractId.toString(), contractor, JSON.stringify(newTerms));
+    console.log('*** Transaction committed successfully');
+}
+
+async function raiseDispute(contract: Contract, contractId: number, username: string, reason: string): Promise<any> {
+    console.log('\n--> Submit Transaction: RaiseDispute, function opens a dispute and holds payments on the contract in escrow');
+    const resultBytes = await contract.submitTransaction('RaiseDispute', contractId.toString(), username, reason);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Transaction committed successfully');
+    return result;
+}
+
+async function submitEvidence(contract: Contract, disputeId: string, username: string, documentHash: string, description: string): Promise<void> {
+    console.log('\n--> Submit Transaction: SubmitEvidence, function adds the hash of a document to a dispute');
+    await contract.submitTransaction('SubmitEvidence', disputeId, username, documentHash, description);
+    console.log('*** Transaction committed successfully');
+}
+
+async function resolveDispute(contract: Contract, disputeId: string, releasePercent: number, additionalAmount: number, resolution: string): Promise<any> {
+    console.log('\n--> Submit Transaction: ResolveDispute, function closes a dispute and returns the payments that settle it');
+    const resultBytes = await contract.submitTransaction('ResolveDispute', disputeId, releasePercent.toString(), additionalAmount.toString(), resolution);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Transaction committed successfully');
+    return result;
+}
+
+async function submitTimesheet(contract: Contract, contractId: number, contractor: string, hours: number, periodStart: string, periodEnd: string, description: string): Promise<any> {
+    console.log('\n--> Submit Transaction: SubmitTimesheet, function submits hours worked on an hourly contract for approval');
+    const resultBytes = await contract.submitTransaction('SubmitTimesheet', contractId.toString(), contractor, hours.toString(), periodStart, periodEnd, description);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Transaction committed successfully');
+    return result;
+}
+
+async function submitDeliverable(contract: Contract, contractId: number, contractor: string, documentHash: string, description: string): Promise<any> {
+    console.log('\n--> Submit Transaction: SubmitDeliverable, function submits the hash of a deliverable on a milestone contract for approval');
+    const resultBytes = await contract.submitTransaction('SubmitDeliverable', contractId.toString(), contractor, documentHash, description);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Transaction committed successfully');
+    return result;
+}
+
+async function approveSubmission(contract: Contract, contractId: number, submissionId: string, manager: string): Promise<void> {
+    console.log('\n--> Submit Transaction: ApproveSubmission, function makes a timesheet or deliverable payable');
+    await contract.submitTransaction('ApproveSubmission', contractId.toString(), submissionId, manager);
+    console.log('*** Transaction committed successfully');
+}
+
+async function rejectSubmission(contract: Contract, contractId: number, submissionId: string, manager: string, reason: string): Promise<void> {
+    console.log('\n--> Submit Transaction: RejectSubmission, function declines a timesheet or deliverable');
+    await contract.submitTransaction('RejectSubmission', contractId.toString(), submissionId, manager, reason);
+    console.log('*** Transaction committed successfully');
+}
+
+async function getSubmissions(contract: Contract, contractId: number): Promise<any[]> {
+    console.log('\n--> Evaluate Transaction: GetSubmissions, function returns the timesheets and deliverables submitted on a contract');
+    const resultBytes = await contract.evaluateTransaction('GetSubmissions', contractId.toString());
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Result:', result);
+    return result;
+}
+
+async function updateUserProfile(contract: Contract, username: string, password: string, name: string, bank: string, bankAccountNo: string, centralBankID: string, company: string, repointContracts: boolean): Promise<void> {
+    console.log('\n--> Submit Transaction: UpdateUserProfile, function changes the details and verified bank account of a user');
+    await contract.submitTransaction('UpdateUserProfile', username, password, name, bank, bankAccountNo, centralBankID, company, repointContracts.toString());
+    console.log('*** Transaction committed successfully');
+}
+
+async function requestAccountChange(contract: Contract, contractId: number, username: string): Promise<void> {
+    console.log('\n--> Submit Transaction: RequestAccountChange, function asks the counterparty to pay a contract to the user\'s new account');
+    await contract.submitTransaction('RequestAccountChange', contractId.toString(), username);
+    console.log('*** Transaction committed successfully');
+}
+
+async function approveAccountChange(contract: Contract, contractId: number, username: string, approver: string): Promise<void> {
+    console.log('\n--> Submit Transaction: ApproveAccountChange, function applies a requested account change to a contract');
+    await contract.submitTransaction('ApproveAccountChange', contractId.toString(), username, approver);
+    console.log('*** Transaction committed successfully');
+}
+
+async function rejectAccountChange(contract: Contract, contractId: number, username: string, approver: string, reason: string): Promise<void> {
+    console.log('\n--> Submit Transaction: RejectAccountChange, function declines a requested account change');
+    await contract.submitTransaction('RejectAccountChange', contractId.toString(), username, approver, reason);
+    console.log('*** Transaction committed successfully');
+}
+
+async function getAccountChange(contract: Contract, contractId: string, username: string): Promise<any> {
+    console.log('\n--> Evaluate Transaction: GetAccountChange, function returns the latest account change a party requested on a contract');
+    const resultBytes = await contract.evaluateTransaction('GetAccountChange', contractId, username);
+    const resultJson = utf8Decoder.decode(resultBytes);
+    const result = JSON.parse(resultJson);
+    console.log('*** Result:', result);
+    return result;
+}
+
+async function registerPublicKey(contract: Contract, username: string, password: string, publicKey: string): Promise<void> {
+    console.log('\n--> Submit Transaction: RegisterPublicKey, function sets the key a user signs contract documents with');
+    await contract.submitTransaction('RegisterPublicKey', username, password, publicKey);
+    console.log('*** Transaction committed successfully');
+}
+
+async function attachContractDocument(contract: Contract, contractId: number, manager: string, contractor: string, documentHash: string): Promise<void> {
+    console.log('\n--> Submit Transaction: AttachContractDocument, function anchors the hash of the agreement to a requested contract');
+    await contract.submitTransaction('AttachContractDocument', contractId.toString(), manager, contractor, documentHash);
+    console.log('*** Transaction committed successfully');
+}
+
+async function getContractTerms(contract: Contract, contractId: number): Promise<string> {
+    console.log('\n--> Evaluate Transaction: GetContractTerms, function returns the canonical terms the parties sign');
+    const resultBytes = await contract.evaluateTransaction('GetContractTerms', contractId.toString());
+    const result = utf8Decoder.decode(resultBytes);
+    console.log('*** Result:', result);
+    return result;
+}
+
+async function signContract(contract: Contract, contractId: number, username: string, signature: string): Promise<void> {
+    console.log('\n--> Submit Transaction: SignContract, function records a party signature over the contract terms and document');
+    await contract.submitTransaction('SignContract', contractId.toString(), username, signature);
+    console.log('*** Transaction committed successfully');
+}
+
+async function verifyContractDocument(contract: Contract, contractId: number, hash: string): Promise<any> {
+    console.log('\n--> Evaluate Transaction: VerifyContractDocument, function checks a document hash and the signatures on a contract');
+    const resultBytes = await contract.evaluateTransaction('VerifyContractDocument', contractId.toString(), hash);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Result:', result);
+    return result;
+}
+
+async function getContractDisputes(contract: Contract, contractId: number): Promise<any[]> {
+    console.log('\n--> Evaluate Transaction: GetContractDisputes, function returns the disputes raised on a contract');
+    const resultBytes = await contract.evaluateTransaction('GetContractDisputes', contractId.toString());
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Result:', result);
+    return result;
+}
+
+async function pay(contract: Contract, currencyFrom: string, currencyTo: string, amount: number, bankAccountFrom: string, bankTo: string, bankAccountTo: string, paymentType: string): Promise<string> {
     console.log('\n--> Submit Transaction: Pay, function pays the specified amount from one bank account to another');
-    await contract.submitTransaction(
-        'Pay',
-        currencyFrom,
-        currencyTo,
-        amount.toString(),
-        bankAccountFrom,
-        bankTo,
-        bankAccountTo
-    );
+    const proposal = contract.newProposal('Pay', { arguments: [currencyFrom, currencyTo, amount.toString(), bankAccountFrom, bankTo, bankAccountTo, paymentType, ''] });
+    const transaction = await proposal.endorse();
+    const commit = await transaction.submit();
+    const status = await commit.getStatus();
+    if (!status.successful) {
+        throw new Error(`Pay transaction ${status.transactionId} failed with status code ${status.code}`);
+    }
     console.log('*** Transaction committed successfully');
+    return transaction.getTransactionId();
+}
+
+/**
+ * paySplits() pays each split of a redemption from the manager's bank in its own Pay transaction and records it
+ * on the contract, skipping the splits already recorded as paid so that a failed fan-out can be resumed.
+ * A split whose payment committed but could not be recorded is reported as PaidNotRecorded and must not be retried.
+ */
+async function paySplits(contract: Contract, bank: Contract, currencyFrom: string, bankAccountFrom: string, contractId: number, manager: string, redemption: string, splits: any[]): Promise<any[]> {
+    const paid = new Set((await getSplitPayments(contract, contractId, redemption)).map((payment: any) => payment.index));
+    const payments = [];
+    for (const [index, split] of splits.entries()) {
+        if (split.amount <= 0) {
+            continue;
+        }
+        if (paid.has(index)) {
+            payments.push({ contractId, index, username: split.username, status: 'AlreadyPaid' });
+            continue;
+        }
+        let paymentTxId: string;
+        try {
+            paymentTxId = await pay(bank, currencyFrom, split.currency, split.amount, bankAccountFrom, split.bank.toLowerCase(), split.bankAccountNo, split.paymentType);
+        } catch (error) {
+            console.error(`Error paying ${split.username} on contract ${contractId}:`, error);
+            payments.push({ contractId, index, username: split.username, status: 'PaymentFailed', error: String(error) });
+            continue;
+        }
+        try {
+            await recordSplitPayment(contract, contractId, manager, redemption, index, split.username, split.amount, paymentTxId);
+            payments.push({ contractId, index, username: split.username, status: 'Paid', paymentTxId });
+        } catch (error) {
+            console.error(`Error recording the payment of ${split.username} on contract ${contractId}:`, error);
+            payments.push({ contractId, index, username: split.username, status: 'PaidNotRecorded', paymentTxId, error: String(error) });
+        }
+    }
+    return payments;
+}
+
+async function redeem(contract: Contract, contractId: number, manager: string, contractor: string, currentDate: string): Promise<{ amount: number, txId: string }> {
+    console.log('\n--> Submit Transaction: CalculateRedemptionAmount, function redeems the amount due on a contract');
+    const proposal = contract.newProposal('CalculateRedemptionAmount', { arguments: [contractId.toString(), manager, contractor, currentDate] });
+    const transaction = await proposal.endorse();
+    const commit = await transaction.submit();
+    const status = await commit.getStatus();
+    if (!status.successful) {
+        throw new Error(`CalculateRedemptionAmount transaction ${status.transactionId} failed with status code ${status.code}`);
+    }
+    const amount = JSON.parse(utf8Decoder.decode(transaction.getResult()));
+    console.log('*** Transaction committed successfully');
+    return { amount, txId: transaction.getTransactionId() };
+}
+
+async function recordSplitPayment(contract: Contract, contractId: number, manager: string, redemption: string, index: number, username: string, amount: number, paymentTxId: string): Promise<void> {
+    console.log('\n--> Submit Transaction: RecordSplitPayment, function records that a split of a redemption was paid');
+    await contract.submitTransaction('RecordSplitPayment', contractId.toString(), manager, redemption, index.toString(), username, amount.toString(), paymentTxId);
+    console.log('*** Transaction committed successfully');
+}
+
+async function getSplitPayments(contract: Contract, contractId: number, redemption: string): Promise<any[]> {
+    console.log('\n--> Evaluate Transaction: GetSplitPayments, function lists the paid splits of a redemption');
+    const resultBytes = await contract.evaluateTransaction('GetSplitPayments', contractId.toString(), redemption);
+    const result = JSON.parse(utf8Decoder.decode(resultBytes));
+    console.log('*** Result:', result);
+    return result;
 }
 
 async function calculateRedemptionAmount(contract : Contract, contractId:number, manager:string, contractor:string, currentDate:string): Promise<number> {
@@ -584,6 +1275,29 @@ async function calculateRedemptionAmount(contract : Contract, contractId:number,
     return result;
 }
 
+async function getPaymentSplits(contract: Contract, contractId: number, manager: string, amount: number): Promise<any[]> {
+    console.log('\n--> Evaluate Transaction: GetPaymentSplits, function returns the payment to each party for a redeemed amount');
+    const resultBytes = await contract.evaluateTransaction('GetPaymentSplits', contractId.toString(), manager, amount.toString());
+    const resultJson = utf8Decoder.decode(resultBytes);
+    const result = JSON.parse(resultJson);
+    console.log('*** Result:', result);
+    return result;
+}
+
+async function runPayroll(contract: Contract, asOfDate: string): Promise<{ run: any, txId: string }> {
+    console.log('\n--> Submit Transaction: RunPayroll, function redeems every contract due as of a date');
+    const proposal = contract.newProposal('RunPayroll', { arguments: [asOfDate] });
+    const transaction = await proposal.endorse();
+    const commit = await transaction.submit();
+    const status = await commit.getStatus();
+    if (!status.successful) {
+        throw new Error(`RunPayroll transaction ${status.transactionId} failed with status code ${status.code}`);
+    }
+    const run = JSON.parse(utf8Decoder.decode(transaction.getResult()));
+    console.log('*** Transaction committed successfully');
+    return { run, txId: transaction.getTransactionId() };
+}
+
 /**
  * envOrDefault() will return the value of an environment variable, or a default value if the variable is undefined.
  */